@@ -13,7 +13,10 @@ import (
 )
 
 var _issuer = "webutility"
-var _secret = "webutility"
+var _keys = NewKeySet(NewHMACKey("", []byte("webutility")))
+
+// AuthTokenRefreshWindow is how long after expiration RefreshAuthToken still accepts a token.
+var AuthTokenRefreshWindow = time.Hour
 
 // TokenClaims are JWT token claims.
 type TokenClaims struct {
@@ -27,27 +30,49 @@ type TokenClaims struct {
 	RoleName  string `json:"role"`
 	RoleID    int64  `json:"role_id"`
 	ExpiresIn int64  `json:"expires_in"`
+
+	// Extra holds claims added with WithClaim
+	Extra map[string]interface{} `json:"ext,omitempty"`
+
+	// Partial is set for tokens awaiting second factor, see NewPartialAuthToken
+	Partial bool `json:"mfa_pending,omitempty"`
 }
 
-// InitJWT ...
+// InitJWT sets up HS256 signing with secret.
 func InitJWT(issuer, secret string) {
 	_issuer = issuer
-	_secret = secret
+	_keys = NewKeySet(NewHMACKey("", []byte(secret)))
 }
 
-// ValidateHash hashes pass and salt and returns comparison result with resultHash
+// InitJWTKeys sets up signing and verification with keys from ks.
+// Use ks.Rotate to introduce new signing keys at runtime.
+func InitJWTKeys(issuer string, ks *KeySet) {
+	_issuer = issuer
+	_keys = ks
+}
+
+// JWTKeys returns key set used for signing and verifying tokens.
+func JWTKeys() *KeySet {
+	return _keys
+}
+
+// ValidateHash hashes pass and salt and returns comparison result with resultHash.
+// Hashes are compared in constant time.
 func ValidateHash(pass, salt, resultHash string) (bool, error) {
 	hash, _, err := CreateHash(pass, salt)
 	if err != nil {
 		return false, err
 	}
-	res := hash == resultHash
+	res := constantTimeEqualHex(hash, resultHash)
 	return res, nil
 }
 
 // CreateHash hashes str using SHA256.
 // If the presalt parameter is not provided CreateHash will generate new salt string.
 // Returns hash and salt strings or an error if it fails.
+//
+// Deprecated: SHA256 is too fast for storing passwords, use HashPassword.
+// CreateHash is kept unchanged so existing hashes can still be verified.
 func CreateHash(str, presalt string) (hash, salt string, err error) {
 	// chech if message is presalted
 	if presalt == "" {
@@ -80,70 +105,63 @@ func CreateHash(str, presalt string) (hash, salt string, err error) {
 }
 
 // CreateAuthToken returns JWT token with encoded username, role, expiration date and issuer claims.
+// Token expires after DefaultTokenLifetime, use NewAuthToken for other options.
 // It returns an error if it fails.
 func CreateAuthToken(username string, roleName string, roleID int64) (TokenClaims, error) {
-	t0 := (time.Now()).Unix()
-	t1 := (time.Now().Add(time.Hour * 24 * 7)).Unix()
-	claims := TokenClaims{
-		TokenType: "Bearer",
-		Username:  username,
-		RoleName:  roleName,
-		RoleID:    roleID,
-		ExpiresIn: t1 - t0,
-	}
-	// initialize jwt.StandardClaims fields (anonymous struct)
-	claims.IssuedAt = t0
-	claims.ExpiresAt = t1
-	claims.Issuer = _issuer
-
-	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	token, err := jwtToken.SignedString([]byte(_secret))
-	if err != nil {
-		return TokenClaims{}, err
-	}
-	claims.Token = token
-	return claims, nil
+	return NewAuthToken(username, roleName, roleID)
 }
 
 // RefreshAuthToken returns new JWT token with same claims contained in tok but with prolonged expiration date.
-// It returns an error if it fails.
+// tok is revoked and can't be refreshed again. It returns an error if it fails, if tok has been revoked,
+// has no token ID or expired more than AuthTokenRefreshWindow ago.
+//
+// Deprecated: use IssueTokenPair and RefreshTokens.
 func RefreshAuthToken(tok string) (TokenClaims, error) {
-	token, err := jwt.ParseWithClaims(tok, &TokenClaims{}, secretFunc)
+	// don't return error if token has recently expired, just extend it
+	claims, err := ParseAuthToken(tok, AllowExpired())
 	if err != nil {
-		if validation, ok := err.(*jwt.ValidationError); ok {
-			// don't return error if token is expired, just extend it
-			if !(validation.Errors&jwt.ValidationErrorExpired != 0) {
-				return TokenClaims{}, err
-			}
-		} else {
-			return TokenClaims{}, err
-		}
+		return TokenClaims{}, err
+	}
+
+	if claims.Partial {
+		return TokenClaims{}, ErrSecondFactorRequired
 	}
 
-	// type assertion
-	claims, ok := token.Claims.(*TokenClaims)
-	if !ok {
-		return TokenClaims{}, errors.New("token is not valid")
+	expires := time.Unix(claims.ExpiresAt, 0)
+	if claims.ExpiresAt == 0 || time.Now().After(expires.Add(AuthTokenRefreshWindow)) {
+		return TokenClaims{}, errors.New("token has expired")
+	}
+
+	// token stays on the list as long as it could be refreshed
+	if claims.Id == "" {
+		return TokenClaims{}, ErrTokenHasNoID
+	}
+	if err = _revoked.Revoke(claims.Id, expires.Add(AuthTokenRefreshWindow)); err != nil {
+		return TokenClaims{}, err
 	}
 
 	// extend token expiration date
-	return CreateAuthToken(claims.Username, claims.RoleName, claims.RoleID)
+	return NewAuthToken(claims.Username, claims.RoleName, claims.RoleID, WithClaims(claims.Extra))
 }
 
 // AuthCheck ...
 func AuthCheck(req *http.Request, roles string) (*TokenClaims, error) {
 	// validate token and check expiration date
-	claims, err := GetTokenClaims(req)
+	claims, err := RequestClaims(req)
 	if err != nil {
 		return claims, err
 	}
 
+	if claims.Partial {
+		return claims, ErrSecondFactorRequired
+	}
+
 	if roles == "" {
 		return claims, nil
 	}
 
-	// check if token has expired
-	if claims.ExpiresAt < (time.Now()).Unix() {
+	// check if token has expired, API key and Basic principals may have no expiration date
+	if claims.ExpiresAt != 0 && claims.ExpiresAt < (time.Now()).Unix() {
 		return claims, errors.New("token has expired")
 	}
 
@@ -174,44 +192,27 @@ func GetTokenClaims(req *http.Request) (*TokenClaims, error) {
 		return &TokenClaims{}, errors.New("authorization header is incomplete")
 	}
 
-	token, err := jwt.ParseWithClaims(tokstr, &TokenClaims{}, secretFunc)
+	claims, err := ParseAuthToken(tokstr)
 	if err != nil {
 		return &TokenClaims{}, err
 	}
 
-	// type assertion
-	claims, ok := token.Claims.(*TokenClaims)
-	if !ok || !token.Valid {
-		return &TokenClaims{}, errors.New("token is not valid")
-	}
-
 	return claims, nil
 }
 
-func DecodeJWT(secret, token string) (*TokenClaims, error) {
-	secretfunc := func(*jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
+// DecodeJWT validates token signed with HS256 secret and returns its claims.
+// Expired tokens are rejected unless AllowExpired option is given.
+func DecodeJWT(secret, token string, opts ...ParseOption) (*TokenClaims, error) {
+	o := parseOptions{
+		keyfunc: func(*jwt.Token) (interface{}, error) {
+			return []byte(secret), nil
+		},
 	}
-
-	tok, err := jwt.ParseWithClaims(token, &TokenClaims{}, secretfunc)
-	if err != nil {
-		if validation, ok := err.(*jwt.ValidationError); ok {
-			// don't return error if token is expired
-			if !(validation.Errors&jwt.ValidationErrorExpired != 0) {
-				return nil, err
-			}
-		} else {
-			return nil, err
-		}
-	}
-
-	// type assertion
-	claims, ok := tok.Claims.(*TokenClaims)
-	if !ok {
-		return &TokenClaims{}, errors.New("token is not valid")
+	for _, opt := range opts {
+		opt(&o)
 	}
 
-	return claims, nil
+	return parseToken(token, o)
 }
 
 // randomSalt returns a string of 32 random characters.
@@ -229,7 +230,11 @@ func randomSalt() (s string, err error) {
 	return s, nil
 }
 
-// secretFunc returns byte slice of API secret keyword.
-func secretFunc(token *jwt.Token) (interface{}, error) {
-	return []byte(_secret), nil
+// randomToken returns hex encoded string of size random bytes.
+func randomToken(size int) (string, error) {
+	raw := make([]byte, size)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
 }
@@ -0,0 +1,323 @@
+package webutility
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authentication schemes, stored in TokenClaims.TokenType.
+const (
+	SchemeBearer = "Bearer"
+	SchemeAPIKey = "ApiKey"
+	SchemeBasic  = "Basic"
+)
+
+// ErrNoCredentials is returned by an Authenticator when request doesn't carry
+// credentials of its scheme, so the next Authenticator in the chain can be tried.
+var ErrNoCredentials = errors.New("no credentials provided")
+
+// ErrInvalidCredentials ...
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// headers and query parameters masked by RedactCredentials
+var (
+	secretsMu     sync.RWMutex
+	secretHeaders = map[string]bool{}
+	secretParams  = map[string]bool{}
+)
+
+// Authenticator validates credentials from a request and returns principal as TokenClaims.
+type Authenticator interface {
+	Authenticate(req *http.Request) (*TokenClaims, error)
+}
+
+// AuthenticatorFunc is an adapter that allows use of ordinary functions as Authenticator.
+type AuthenticatorFunc func(req *http.Request) (*TokenClaims, error)
+
+// Authenticate ...
+func (f AuthenticatorFunc) Authenticate(req *http.Request) (*TokenClaims, error) {
+	return f(req)
+}
+
+// AuthChain tries authenticators in order. The first one that finds credentials decides the outcome.
+type AuthChain []Authenticator
+
+// Authenticate ...
+func (c AuthChain) Authenticate(req *http.Request) (*TokenClaims, error) {
+	for _, a := range c {
+		claims, err := a.Authenticate(req)
+		if err == ErrNoCredentials {
+			continue
+		}
+		return claims, err
+	}
+	return nil, ErrNoCredentials
+}
+
+// BearerAuth returns Authenticator for JWT tokens in 'Authorization: Bearer' header.
+func BearerAuth() Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) (*TokenClaims, error) {
+		if !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") {
+			return nil, ErrNoCredentials
+		}
+		return GetTokenClaims(req)
+	})
+}
+
+// APIKey is a stored API key record. Key itself is never stored, only its hash.
+type APIKey struct {
+	Hash      string
+	Name      string
+	Username  string
+	RoleName  string
+	RoleID    int64
+	ExpiresAt time.Time // zero value means the key never expires
+}
+
+// APIKeyStore looks up API keys by hash. It returns ErrInvalidCredentials for unknown keys.
+type APIKeyStore interface {
+	FindAPIKey(hash string) (APIKey, error)
+}
+
+// GenerateAPIKey returns new random key to hand out to the client and its hash to be stored.
+func GenerateAPIKey() (key, hash string, err error) {
+	if key, err = randomToken(32); err != nil {
+		return "", "", err
+	}
+	return key, HashAPIKey(key), nil
+}
+
+// HashAPIKey ...
+func HashAPIKey(key string) string {
+	return hashToken(key)
+}
+
+// APIKeyAuth returns Authenticator for API keys sent in header or, if param is not empty,
+// in query parameter param. Both are masked by RedactCredentials.
+func APIKeyAuth(store APIKeyStore, header, param string) Authenticator {
+	secretsMu.Lock()
+	secretHeaders[http.CanonicalHeaderKey(header)] = true
+	if param != "" {
+		secretParams[param] = true
+	}
+	secretsMu.Unlock()
+
+	return AuthenticatorFunc(func(req *http.Request) (*TokenClaims, error) {
+		key := req.Header.Get(header)
+		if key == "" && param != "" {
+			key = req.URL.Query().Get(param)
+		}
+		if key == "" {
+			return nil, ErrNoCredentials
+		}
+
+		k, err := store.FindAPIKey(HashAPIKey(key))
+		if err != nil {
+			return nil, err
+		}
+
+		if !k.ExpiresAt.IsZero() && time.Now().After(k.ExpiresAt) {
+			return nil, errors.New("api key has expired")
+		}
+
+		claims := &TokenClaims{
+			TokenType: SchemeAPIKey,
+			Username:  k.Username,
+			RoleName:  k.RoleName,
+			RoleID:    k.RoleID,
+		}
+		claims.Subject = k.Name
+		if !k.ExpiresAt.IsZero() {
+			claims.ExpiresAt = k.ExpiresAt.Unix()
+		}
+
+		return claims, nil
+	})
+}
+
+// RedactCredentials returns copy of req with API keys (see APIKeyAuth) and websocket token
+// (see RedactWebSocketToken) masked, for logging. req is returned as is if it carries none of them.
+func RedactCredentials(req *http.Request) *http.Request {
+	r := RedactWebSocketToken(req)
+
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+
+	q := r.URL.Query()
+	var params, headers []string
+	for name := range q {
+		if secretParams[name] {
+			params = append(params, name)
+		}
+	}
+	for name := range r.Header {
+		if secretHeaders[name] {
+			headers = append(headers, name)
+		}
+	}
+	if len(params) == 0 && len(headers) == 0 {
+		return r
+	}
+
+	if r == req {
+		r = req.Clone(req.Context())
+	}
+	if len(params) > 0 {
+		for _, name := range params {
+			q.Set(name, "REDACTED")
+		}
+		r.URL.RawQuery = q.Encode()
+		r.RequestURI = r.URL.RequestURI()
+	}
+	for _, name := range headers {
+		r.Header.Set(name, "REDACTED")
+	}
+	return r
+}
+
+// BasicUser is a user record used by BasicAuth. If Salt is empty, Hash is expected
+// to be in format produced by HashPassword, otherwise by CreateHash.
+type BasicUser struct {
+	Username string
+	RoleName string
+	RoleID   int64
+	Hash     string
+	Salt     string
+}
+
+// BasicUserStore looks up users by username. It returns ErrInvalidCredentials for unknown users.
+type BasicUserStore interface {
+	FindBasicUser(username string) (BasicUser, error)
+}
+
+// BasicUserStoreFunc is an adapter that allows use of ordinary functions as BasicUserStore.
+type BasicUserStoreFunc func(username string) (BasicUser, error)
+
+// FindBasicUser ...
+func (f BasicUserStoreFunc) FindBasicUser(username string) (BasicUser, error) {
+	return f(username)
+}
+
+// BasicAuth returns Authenticator for HTTP Basic credentials.
+func BasicAuth(store BasicUserStore) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) (*TokenClaims, error) {
+		username, pass, ok := req.BasicAuth()
+		if !ok {
+			return nil, ErrNoCredentials
+		}
+
+		u, err := store.FindBasicUser(username)
+		if err != nil {
+			return nil, err
+		}
+
+		if u.Salt != "" {
+			ok, err = ValidateHash(pass, u.Salt, u.Hash)
+		} else {
+			ok, _, err = VerifyPassword(pass, u.Hash)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrInvalidCredentials
+		}
+
+		return &TokenClaims{
+			TokenType: SchemeBasic,
+			Username:  u.Username,
+			RoleName:  u.RoleName,
+			RoleID:    u.RoleID,
+		}, nil
+	})
+}
+
+// MemoryAPIKeyStore ...
+type MemoryAPIKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]APIKey
+}
+
+// NewMemoryAPIKeyStore ...
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{
+		keys: make(map[string]APIKey),
+	}
+}
+
+// Add stores k under k.Hash.
+func (s *MemoryAPIKeyStore) Add(k APIKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[k.Hash] = k
+}
+
+// Remove ...
+func (s *MemoryAPIKeyStore) Remove(hash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, hash)
+}
+
+// FindAPIKey ...
+func (s *MemoryAPIKeyStore) FindAPIKey(hash string) (APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	k, ok := s.keys[hash]
+	if !ok {
+		return APIKey{}, ErrInvalidCredentials
+	}
+	return k, nil
+}
+
+// SQLAPIKeyStore reads API keys from a database table:
+//
+//	create table api_keys (
+//	    key_hash   varchar(64) primary key,
+//	    name       varchar(255),
+//	    username   varchar(255),
+//	    role_name  varchar(255),
+//	    role_id    integer,
+//	    expires_at integer
+//	)
+//
+// Supported drivers are "ora" and "mysql".
+type SQLAPIKeyStore struct {
+	db    *sql.DB
+	drv   string
+	table string
+}
+
+// NewSQLAPIKeyStore ...
+func NewSQLAPIKeyStore(drv string, db *sql.DB, table string) (*SQLAPIKeyStore, error) {
+	if drv != "ora" && drv != "mysql" {
+		return nil, errors.New("driver not supported")
+	}
+	return &SQLAPIKeyStore{db: db, drv: drv, table: table}, nil
+}
+
+// FindAPIKey ...
+func (s *SQLAPIKeyStore) FindAPIKey(hash string) (k APIKey, err error) {
+	var expires sql.NullInt64
+	q := fmt.Sprintf("select name, username, role_name, role_id, expires_at from %s where key_hash = %s",
+		s.table, bindVars(s.drv, 1))
+	err = s.db.QueryRow(q, hash).Scan(&k.Name, &k.Username, &k.RoleName, &k.RoleID, &expires)
+	if err == sql.ErrNoRows {
+		return k, ErrInvalidCredentials
+	} else if err != nil {
+		return k, err
+	}
+
+	k.Hash = hash
+	if expires.Valid && expires.Int64 > 0 {
+		k.ExpiresAt = time.Unix(expires.Int64, 0)
+	}
+
+	return k, nil
+}
@@ -0,0 +1,157 @@
+package webutility
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthChain(t *testing.T) {
+	var tried []string
+	auth := func(name string, err error) Authenticator {
+		return AuthenticatorFunc(func(req *http.Request) (*TokenClaims, error) {
+			tried = append(tried, name)
+			if err != nil {
+				return nil, err
+			}
+			return &TokenClaims{Username: name}, nil
+		})
+	}
+
+	tests := []struct {
+		name  string
+		chain AuthChain
+		user  string
+		err   error
+		tried string
+	}{
+		{"first wins", AuthChain{auth("a", nil), auth("b", nil)}, "a", nil, "a"},
+		{"no credentials falls through", AuthChain{auth("a", ErrNoCredentials), auth("b", nil)}, "b", nil, "a,b"},
+		{"invalid credentials stop", AuthChain{auth("a", ErrInvalidCredentials), auth("b", nil)}, "", ErrInvalidCredentials, "a"},
+		{"nothing found", AuthChain{auth("a", ErrNoCredentials), auth("b", ErrNoCredentials)}, "", ErrNoCredentials, "a,b"},
+		{"empty", AuthChain{}, "", ErrNoCredentials, ""},
+	}
+	for _, tt := range tests {
+		tried = nil
+		claims, err := tt.chain.Authenticate(httptest.NewRequest("GET", "/", nil))
+		if err != tt.err {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+		if tt.err == nil && (claims == nil || claims.Username != tt.user) {
+			t.Errorf("%s: claims = %+v, want user %s", tt.name, claims, tt.user)
+		}
+		if got := strings.Join(tried, ","); got != tt.tried {
+			t.Errorf("%s: tried %s, want %s", tt.name, got, tt.tried)
+		}
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	store := NewMemoryAPIKeyStore()
+	key, hash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Add(APIKey{Hash: hash, Name: "ci", Username: "alice", RoleName: "admin", RoleID: 1})
+	expired, expiredHash, _ := GenerateAPIKey()
+	store.Add(APIKey{Hash: expiredHash, Username: "bob", ExpiresAt: time.Now().Add(-time.Minute)})
+
+	auth := APIKeyAuth(store, "X-API-Key", "api_key")
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-API-Key", key)
+	claims, err := auth.Authenticate(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.TokenType != SchemeAPIKey || claims.Username != "alice" || claims.Subject != "ci" {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	if claims, err = auth.Authenticate(httptest.NewRequest("GET", "/?api_key="+key, nil)); err != nil || claims.Username != "alice" {
+		t.Errorf("key in query: claims = %+v, err = %v", claims, err)
+	}
+
+	if _, err = auth.Authenticate(httptest.NewRequest("GET", "/", nil)); err != ErrNoCredentials {
+		t.Errorf("no key: err = %v, want ErrNoCredentials", err)
+	}
+	if _, err = auth.Authenticate(httptest.NewRequest("GET", "/?api_key=unknown", nil)); err != ErrInvalidCredentials {
+		t.Errorf("unknown key: err = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err = auth.Authenticate(httptest.NewRequest("GET", "/?api_key="+expired, nil)); err == nil {
+		t.Error("expired key accepted")
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	modern, err := testPasswordPolicy.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, salt, err := CreateHash("secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := map[string]BasicUser{
+		"alice": {Username: "alice", RoleName: "admin", Hash: modern},
+		"bob":   {Username: "bob", RoleName: "user", Hash: legacy, Salt: salt},
+	}
+	auth := BasicAuth(BasicUserStoreFunc(func(username string) (BasicUser, error) {
+		if u, ok := users[username]; ok {
+			return u, nil
+		}
+		return BasicUser{}, ErrInvalidCredentials
+	}))
+
+	tests := []struct {
+		user, pass string
+		err        error
+	}{
+		{"alice", "secret", nil},
+		{"bob", "secret", nil},
+		{"alice", "wrong", ErrInvalidCredentials},
+		{"bob", "wrong", ErrInvalidCredentials},
+		{"carol", "secret", ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth(tt.user, tt.pass)
+		claims, err := auth.Authenticate(req)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s/%s: err = %v, want %v", tt.user, tt.pass, err, tt.err)
+		}
+		if tt.err == nil && (claims.TokenType != SchemeBasic || claims.Username != tt.user) {
+			t.Errorf("%s: claims = %+v", tt.user, claims)
+		}
+	}
+
+	if _, err = auth.Authenticate(httptest.NewRequest("GET", "/", nil)); err != ErrNoCredentials {
+		t.Errorf("no credentials: err = %v, want ErrNoCredentials", err)
+	}
+}
+
+func TestRedactCredentials(t *testing.T) {
+	APIKeyAuth(NewMemoryAPIKeyStore(), "X-Secret-Key", "secret_key")
+
+	req := httptest.NewRequest("GET", "/export?secret_key=k1&format=csv", nil)
+	req.Header.Set("X-Secret-Key", "k2")
+
+	r := RedactCredentials(req)
+	if strings.Contains(r.RequestURI, "k1") || strings.Contains(r.URL.String(), "k1") || r.Header.Get("X-Secret-Key") != "REDACTED" {
+		t.Errorf("key not redacted: %s %v", r.RequestURI, r.Header)
+	}
+	if r.URL.Query().Get("format") != "csv" {
+		t.Errorf("other query parameters lost: %s", r.URL)
+	}
+	if req.URL.Query().Get("secret_key") != "k1" || req.Header.Get("X-Secret-Key") != "k2" {
+		t.Error("original request was modified")
+	}
+
+	plain := httptest.NewRequest("GET", "/export?format=csv", nil)
+	if RedactCredentials(plain) != plain {
+		t.Error("request without credentials was copied")
+	}
+}
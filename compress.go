@@ -0,0 +1,340 @@
+package webutility
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CompressMinSize is the smallest response body, in bytes, that gets compressed.
+var CompressMinSize = 1024
+
+// Compressor returns writer that compresses data written to it into w.
+type Compressor func(w io.Writer) (io.WriteCloser, error)
+
+type contentCompressor struct {
+	encoding string
+	compress Compressor
+}
+
+var (
+	compressorsMu sync.RWMutex
+	// order is server's preference on equal client weights
+	compressors = []contentCompressor{
+		{"gzip", func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, gzip.DefaultCompression)
+		}},
+		{"deflate", func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, flate.DefaultCompression)
+		}},
+	}
+
+	// incompressibleTypes are content types (or their prefixes) that are already compressed
+	// or streamed and are never compressed.
+	incompressibleTypes = []string{
+		"image/", "video/", "audio/", "font/woff",
+		"application/zip", "application/gzip", "application/x-gzip", "application/x-7z-compressed",
+		"application/x-rar-compressed", "application/octet-stream", "application/pdf",
+		"text/event-stream",
+	}
+)
+
+// trimETagEncoding returns entity tag tag without encoding suffix added by Compress.
+func trimETagEncoding(tag string) string {
+	if !strings.HasSuffix(tag, `"`) {
+		return tag
+	}
+
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	for _, c := range compressors {
+		if suffix := "-" + c.encoding + `"`; strings.HasSuffix(tag, suffix) {
+			return tag[:len(tag)-len(suffix)] + `"`
+		}
+	}
+	return tag
+}
+
+// RegisterCompressor registers c for content encoding, replacing any existing compressor for it.
+// New encodings are preferred over existing ones on equal client weights, so registering
+// "br" makes brotli the first choice of clients that support it.
+func RegisterCompressor(encoding string, c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+
+	encoding = strings.ToLower(encoding)
+	for i := range compressors {
+		if compressors[i].encoding == encoding {
+			compressors[i].compress = c
+			return
+		}
+	}
+	compressors = append([]contentCompressor{{encoding, c}}, compressors...)
+}
+
+// NegotiateEncoding returns the registered content encoding that best matches Accept-Encoding
+// header of req, or "" if response shouldn't be compressed.
+func NegotiateEncoding(req *http.Request) (string, Compressor) {
+	header := req.Header.Get("Accept-Encoding")
+	if header == "" {
+		return "", nil
+	}
+
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		coding, q := strings.TrimSpace(part), 1.0
+		if i := strings.Index(coding, ";"); i != -1 {
+			param := strings.TrimSpace(coding[i+1:])
+			coding = strings.TrimSpace(coding[:i])
+			if strings.HasPrefix(param, "q=") {
+				var err error
+				if q, err = strconv.ParseFloat(param[2:], 64); err != nil {
+					continue
+				}
+			}
+		}
+		weights[strings.ToLower(coding)] = q
+	}
+
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+
+	var (
+		best  contentCompressor
+		bestQ float64
+	)
+	for _, c := range compressors {
+		q, ok := weights[c.encoding]
+		if !ok {
+			q, ok = weights["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = c, q
+		}
+	}
+	return best.encoding, best.compress
+}
+
+// Compress compresses responses of h with encoding negotiated with NegotiateEncoding.
+// Bodies smaller than CompressMinSize, already encoded responses and incompressible content
+// types are sent as is. Strong ETags of compressed responses get encoding suffix ("tag-gzip"),
+// which CheckNotModified and MatchETag ignore. When h writes to a StatusRecorder (LogHTTP)
+// recorder keeps the uncompressed body so logs stay readable.
+func Compress(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding, compressor := NegotiateEncoding(req)
+		if compressor == nil || req.Method == http.MethodHead || req.Header.Get("Upgrade") != "" {
+			h.ServeHTTP(w, req)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			encoding:       encoding,
+			compressor:     compressor,
+		}
+		defer cw.Close()
+
+		h.ServeHTTP(cw, req)
+	})
+}
+
+// compressWriter buffers the beginning of the body until it can decide whether to compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding   string
+	compressor Compressor
+
+	status   int
+	buf      []byte
+	decided  bool
+	hijacked bool
+	enc      io.WriteCloser
+	// dst is where encoded bytes go, it bypasses StatusRecorder's body capture
+	dst io.Writer
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if code < 200 {
+		// informational responses are sent right away
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.status == 0 {
+		cw.status = code
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < CompressMinSize {
+			return len(p), nil
+		}
+		if err := cw.decide(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if cw.enc == nil {
+		return cw.ResponseWriter.Write(p)
+	}
+	if rec, ok := cw.ResponseWriter.(*StatusRecorder); ok {
+		rec.capture(p)
+	}
+	return cw.enc.Write(p)
+}
+
+// decide writes headers and buffered data, compressed if it's worth it.
+func (cw *compressWriter) decide() error {
+	cw.decided = true
+	h := cw.Header()
+
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	if cw.shouldCompress() {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		// compressed body is a different representation and can't share strong validator
+		// with the uncompressed one, see trimETagEncoding
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) && strings.HasSuffix(etag, `"`) && len(etag) > 1 {
+			h.Set("ETag", etag[:len(etag)-1]+"-"+cw.encoding+`"`)
+		}
+
+		cw.dst = cw.ResponseWriter
+		if rec, ok := cw.ResponseWriter.(*StatusRecorder); ok {
+			cw.dst = recorderRawWriter{rec}
+		}
+
+		enc, err := cw.compressor(cw.dst)
+		if err != nil {
+			return err
+		}
+		cw.enc = enc
+	}
+
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := cw.Write(buf)
+	return err
+}
+
+func (cw *compressWriter) shouldCompress() bool {
+	if len(cw.buf) < CompressMinSize {
+		return false
+	}
+	if cw.status < 200 || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified ||
+		cw.status == http.StatusPartialContent {
+		return false
+	}
+
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	ctype := strings.ToLower(h.Get("Content-Type"))
+	if ctype == "image/svg+xml" {
+		return true
+	}
+	for _, t := range incompressibleTypes {
+		if strings.HasPrefix(ctype, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// Flush sends buffered data. Bodies that are flushed before reaching CompressMinSize
+// are not compressed, which keeps streaming responses working.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.decide()
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker. It's only possible before anything was written.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok || cw.decided {
+		return nil, nil, fmt.Errorf("webutility: can't hijack compressed response")
+	}
+	cw.hijacked = true
+	return h.Hijack()
+}
+
+// Push implements http.Pusher.
+func (cw *compressWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := cw.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap ...
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close writes out buffered data and finishes compressed stream.
+func (cw *compressWriter) Close() error {
+	if cw.hijacked {
+		return nil
+	}
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			// handler wrote nothing, let the server send the default response
+			return nil
+		}
+		if err := cw.decide(); err != nil {
+			return err
+		}
+	}
+	if cw.enc != nil {
+		return cw.enc.Close()
+	}
+	return nil
+}
+
+// recorderRawWriter writes to StatusRecorder without capturing the body.
+type recorderRawWriter struct {
+	rec *StatusRecorder
+}
+
+func (w recorderRawWriter) Write(p []byte) (int, error) {
+	n, err := w.rec.writer.Write(p)
+	w.rec.size += n
+	return n, err
+}
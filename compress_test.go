@@ -0,0 +1,52 @@
+package webutility
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompressETag(t *testing.T) {
+	body := strings.Repeat("compressible ", 200)
+	h := Compress(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if CheckNotModified(w, req, `"v1"`, time.Time{}) {
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(body))
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	etag := w.Header().Get("ETag")
+	if etag != `"v1-gzip"` {
+		t.Errorf("ETag = %q, want %q", etag, `"v1-gzip"`)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match %s: status = %d, want 304", etag, w.Code)
+	}
+
+	// uncompressed responses keep the tag as is
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if got := w.Header().Get("ETag"); got != `"v1"` {
+		t.Errorf("identity ETag = %q, want %q", got, `"v1"`)
+	}
+
+	if err := MatchETag(etag, `"v1"`); err != nil {
+		t.Errorf("MatchETag(%s) = %v", etag, err)
+	}
+}
@@ -0,0 +1,33 @@
+package webutility
+
+import (
+	"context"
+	"net/http"
+)
+
+type contextKey int
+
+const (
+	claimsContextKey contextKey = iota
+	corsContextKey
+)
+
+// ContextWithClaims returns a copy of ctx carrying claims.
+func ContextWithClaims(ctx context.Context, claims *TokenClaims) context.Context {
+	return context.WithValue(ctx, claimsContextKey, claims)
+}
+
+// ClaimsFromContext returns claims stored in ctx by ContextWithClaims.
+func ClaimsFromContext(ctx context.Context) (*TokenClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*TokenClaims)
+	return claims, ok && claims != nil
+}
+
+// RequestClaims returns claims from req's context if present,
+// otherwise it extracts them from Authorization header of req.
+func RequestClaims(req *http.Request) (*TokenClaims, error) {
+	if claims, ok := ClaimsFromContext(req.Context()); ok {
+		return claims, nil
+	}
+	return GetTokenClaims(req)
+}
@@ -0,0 +1,213 @@
+package webutility
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CORSPolicy describes which cross-origin requests are allowed.
+type CORSPolicy struct {
+	// AllowedOrigins are exact origins ("https://app.example.com"), patterns ("https://*.example.com")
+	// or "*" for any origin.
+	AllowedOrigins []string
+	// AllowOriginFunc, if set, is consulted for origins not matched by AllowedOrigins.
+	AllowOriginFunc func(origin string) bool
+
+	AllowedMethods []string
+	// AllowedHeaders may contain "*" to allow any requested header.
+	AllowedHeaders []string
+	ExposedHeaders []string
+
+	// AllowCredentials allows cookies and Authorization header for origins matched by exact
+	// origins, patterns or AllowOriginFunc. It's never sent to origins matched only by "*".
+	AllowCredentials bool
+
+	// MaxAge is how long preflight results can be cached. Zero leaves it to the browser.
+	MaxAge time.Duration
+}
+
+var (
+	_corsMu sync.RWMutex
+	_cors   = DefaultCORSPolicy()
+)
+
+// DefaultCORSPolicy returns policy based on the headers set by SetAccessControlHeaders.
+func DefaultCORSPolicy() *CORSPolicy {
+	return &CORSPolicy{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"POST", "GET", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Accept", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization",
+			"If-Match", "If-None-Match"},
+		ExposedHeaders: []string{"ETag"},
+	}
+}
+
+// SetCORSPolicy sets policy used by middleware.SetAccessControlHeaders (and chains built on it).
+// Nil restores DefaultCORSPolicy.
+func SetCORSPolicy(p *CORSPolicy) {
+	if p == nil {
+		p = DefaultCORSPolicy()
+	}
+
+	_corsMu.Lock()
+	defer _corsMu.Unlock()
+	_cors = p
+}
+
+// GetCORSPolicy ...
+func GetCORSPolicy() *CORSPolicy {
+	_corsMu.RLock()
+	defer _corsMu.RUnlock()
+	return _cors
+}
+
+// IsPreflight reports whether req is CORS preflight request.
+func IsPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions &&
+		req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// OriginAllowed reports whether origin matches the policy.
+func (p *CORSPolicy) OriginAllowed(origin string) bool {
+	ok, _ := p.matchOrigin(origin)
+	return ok
+}
+
+// matchOrigin reports whether origin matches the policy and whether it's matched only by "*".
+func (p *CORSPolicy) matchOrigin(origin string) (ok, wildcard bool) {
+	for _, o := range p.AllowedOrigins {
+		if o == "*" {
+			wildcard = true
+			continue
+		}
+		if o == origin {
+			return true, false
+		}
+		if strings.Contains(o, "*") {
+			if ok, _ := path.Match(o, origin); ok {
+				return true, false
+			}
+		}
+	}
+	if p.AllowOriginFunc != nil && p.AllowOriginFunc(origin) {
+		return true, false
+	}
+	return wildcard, wildcard
+}
+
+// varies reports whether responses differ per origin and must be marked with Vary: Origin.
+func (p *CORSPolicy) varies() bool {
+	if p.AllowOriginFunc != nil {
+		return true
+	}
+	for _, o := range p.AllowedOrigins {
+		if o != "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// SetHeaders sets CORS response headers for req. For preflight requests it also sets
+// Allow-Methods, Allow-Headers and Max-Age. Nothing is set if origin isn't allowed.
+// It returns whether req is a preflight, which should be answered without calling the handler.
+func (p *CORSPolicy) SetHeaders(w http.ResponseWriter, req *http.Request) (preflight bool) {
+	h := w.Header()
+	preflight = IsPreflight(req)
+	if preflight {
+		h.Add("Vary", "Origin")
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+	} else if p.varies() {
+		h.Add("Vary", "Origin")
+	}
+
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return preflight
+	}
+	ok, wildcard := p.matchOrigin(origin)
+	if !ok {
+		return preflight
+	}
+
+	if wildcard {
+		// browsers reject credentials with "*", and echoing any origin with them isn't safe
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+		if p.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	if !preflight {
+		if len(p.ExposedHeaders) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+		}
+		return false
+	}
+
+	method := req.Header.Get("Access-Control-Request-Method")
+	if !p.methodAllowed(method) {
+		return true
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+
+	if reqHeaders := req.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+		if p.allowsAnyHeader() {
+			h.Set("Access-Control-Allow-Headers", reqHeaders)
+		} else {
+			h.Set("Access-Control-Allow-Headers", strings.Join(p.AllowedHeaders, ", "))
+		}
+	}
+
+	if p.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge/time.Second)))
+	}
+
+	return true
+}
+
+func (p *CORSPolicy) methodAllowed(method string) bool {
+	for _, m := range p.AllowedMethods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CORSPolicy) allowsAnyHeader() bool {
+	for _, h := range p.AllowedHeaders {
+		if h == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler wraps h with the policy. Preflight requests are answered with 204 No Content
+// and never reach h. Requests passed to h are marked, so SetAccessControlHeaders in h
+// leaves the headers to this policy (see CORSHandled).
+func (p *CORSPolicy) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if p.SetHeaders(w, req) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), corsContextKey, p)))
+	})
+}
+
+// CORSHandled reports whether CORS headers for req were already set by CORSPolicy.Handler.
+func CORSHandled(req *http.Request) bool {
+	p, ok := req.Context().Value(corsContextKey).(*CORSPolicy)
+	return ok && p != nil
+}
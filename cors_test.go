@@ -0,0 +1,103 @@
+package webutility
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSPolicyHandler(t *testing.T) {
+	p := &CORSPolicy{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowedMethods:   []string{"GET", "PUT"},
+		AllowedHeaders:   []string{"Content-Type"},
+		AllowCredentials: true,
+	}
+	called := false
+	h := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest("OPTIONS", "/", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if called || w.Code != http.StatusNoContent {
+		t.Errorf("preflight: called = %v, status = %d", called, w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Allow-Origin = %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Methods"); got != "GET, PUT" {
+		t.Errorf("Allow-Methods = %q", got)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Origin", "https://evil.com")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if !called || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("disallowed origin: called = %v, headers = %v", called, w.Header())
+	}
+
+	// OPTIONS requests that aren't preflights reach the handler
+	called = false
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("OPTIONS", "/", nil))
+	if !called {
+		t.Error("plain OPTIONS request didn't reach the handler")
+	}
+}
+
+func TestSetCORSPolicyNil(t *testing.T) {
+	defer SetCORSPolicy(GetCORSPolicy())
+
+	SetCORSPolicy(nil)
+	p := GetCORSPolicy()
+	if p == nil {
+		t.Fatal("GetCORSPolicy() = nil")
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+	p.SetHeaders(w, req)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Allow-Origin = %q, want *", got)
+	}
+}
+
+func TestCORSWildcardWithCredentials(t *testing.T) {
+	p := &CORSPolicy{
+		AllowedOrigins:   []string{"https://app.example.com", "*"},
+		AllowedMethods:   []string{"GET"},
+		AllowCredentials: true,
+	}
+
+	tests := []struct {
+		origin      string
+		allowOrigin string
+		credentials string
+	}{
+		{"https://app.example.com", "https://app.example.com", "true"},
+		// matched only by "*", the origin isn't echoed and credentials aren't allowed
+		{"https://evil.com", "*", ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Origin", tt.origin)
+		w := httptest.NewRecorder()
+		p.SetHeaders(w, req)
+
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+			t.Errorf("%s: Allow-Origin = %q, want %q", tt.origin, got, tt.allowOrigin)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.credentials {
+			t.Errorf("%s: Allow-Credentials = %q, want %q", tt.origin, got, tt.credentials)
+		}
+		if got := w.Header().Get("Vary"); got != "Origin" {
+			t.Errorf("%s: Vary = %q, want Origin", tt.origin, got)
+		}
+	}
+}
@@ -106,7 +106,7 @@ func ParseDocument(req *http.Request) (doc *Document, err error) {
 		return doc, err
 	}
 
-	claims, _ := web.GetTokenClaims(req)
+	claims, _ := web.RequestClaims(req)
 	owner := claims.Username
 
 	fname := fheader.Filename
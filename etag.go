@@ -0,0 +1,150 @@
+package webutility
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrPreconditionFailed is returned when If-Match or If-Unmodified-Since condition of a request fails.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// ComputeETag returns entity tag of data. Weak tags (W/"...") only promise semantic equivalence,
+// so they're fine for If-None-Match but never satisfy If-Match.
+func ComputeETag(data []byte, weak bool) string {
+	sum := sha256.Sum256(data)
+	tag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+// parseETags splits If-Match or If-None-Match header into entity tags.
+func parseETags(header string) (tags []string) {
+	for _, t := range strings.Split(header, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+func etagWeakMatch(a, b string) bool {
+	return strings.TrimPrefix(trimETagEncoding(a), "W/") == strings.TrimPrefix(b, "W/")
+}
+
+func etagStrongMatch(a, b string) bool {
+	return trimETagEncoding(a) == b && !strings.HasPrefix(a, "W/")
+}
+
+// CheckNotModified sets ETag and Last-Modified headers (if given) and answers GET and HEAD requests
+// with 304 Not Modified if resource matches If-None-Match or If-Modified-Since of req.
+// It returns true if the response was written.
+func CheckNotModified(w http.ResponseWriter, req *http.Request, etag string, lastModified time.Time) bool {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	notModified := false
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		// If-Modified-Since is ignored when If-None-Match is present
+		for _, t := range parseETags(inm) {
+			if t == "*" || (etag != "" && etagWeakMatch(t, etag)) {
+				notModified = true
+				break
+			}
+		}
+	} else if ims := req.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil && !lastModified.Truncate(time.Second).After(t) {
+			notModified = true
+		}
+	}
+
+	if notModified {
+		h := w.Header()
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return notModified
+}
+
+// CheckIfMatch returns ErrPreconditionFailed if current state of the resource, described with etag and
+// lastModified, doesn't satisfy If-Match or If-Unmodified-Since of req. Use it before PUT, PATCH and
+// DELETE for optimistic concurrency control. Empty etag means the resource doesn't exist.
+func CheckIfMatch(req *http.Request, etag string, lastModified time.Time) error {
+	if im := req.Header.Get("If-Match"); im != "" {
+		return MatchETag(im, etag)
+	}
+
+	if ius := req.Header.Get("If-Unmodified-Since"); ius != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(ius); err == nil && lastModified.Truncate(time.Second).After(t) {
+			return ErrPreconditionFailed
+		}
+	}
+
+	return nil
+}
+
+// MatchETag returns ErrPreconditionFailed if If-Match header value ifMatch doesn't match etag.
+// It's useful in Handle functions with a field tagged `header:"If-Match"`.
+func MatchETag(ifMatch, etag string) error {
+	for _, t := range parseETags(ifMatch) {
+		if (t == "*" && etag != "") || (etag != "" && etagStrongMatch(t, etag)) {
+			return nil
+		}
+	}
+	return ErrPreconditionFailed
+}
+
+// CheckPreconditions is like CheckIfMatch but responds with 412 Precondition Failed itself.
+// It returns false if the request should not be processed.
+func CheckPreconditions(w http.ResponseWriter, req *http.Request, etag string, lastModified time.Time) bool {
+	if err := CheckIfMatch(req, etag, lastModified); err != nil {
+		PreconditionFailed(w, req, "resource was modified")
+		return false
+	}
+	return true
+}
+
+// OKWithETag writes payload as JSON with ETag computed from it, or 304 Not Modified if client
+// already has it.
+func OKWithETag(w http.ResponseWriter, req *http.Request, payload interface{}, weak bool) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(payload); err != nil {
+		InternalServerError(w, req, err.Error())
+		return
+	}
+
+	if CheckNotModified(w, req, ComputeETag(buf.Bytes(), weak), time.Time{}) {
+		return
+	}
+
+	SetContentType(w, "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// NotModified ...
+func NotModified(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// PreconditionFailed ...
+func PreconditionFailed(w http.ResponseWriter, r *http.Request, err string) {
+	SetContentType(w, "application/json")
+	Error(w, r, http.StatusPreconditionFailed, err)
+}
@@ -0,0 +1,140 @@
+package webutility
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckNotModified(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	before := modified.Add(-time.Hour).Format(http.TimeFormat)
+	after := modified.Add(time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name    string
+		method  string
+		etag    string
+		headers map[string]string
+		want    bool
+	}{
+		{"strong match", "GET", `"v1"`, map[string]string{"If-None-Match": `"v1"`}, true},
+		{"weak header, strong tag", "GET", `"v1"`, map[string]string{"If-None-Match": `W/"v1"`}, true},
+		{"strong header, weak tag", "GET", `W/"v1"`, map[string]string{"If-None-Match": `"v1"`}, true},
+		{"one of many", "HEAD", `"v2"`, map[string]string{"If-None-Match": `"v1", "v2"`}, true},
+		{"any", "GET", `"v1"`, map[string]string{"If-None-Match": "*"}, true},
+		{"mismatch", "GET", `"v2"`, map[string]string{"If-None-Match": `"v1"`}, false},
+		{"not GET or HEAD", "PUT", `"v1"`, map[string]string{"If-None-Match": `"v1"`}, false},
+		{"not modified since", "GET", "", map[string]string{"If-Modified-Since": after}, true},
+		{"modified since", "GET", "", map[string]string{"If-Modified-Since": before}, false},
+		{
+			name:   "If-Modified-Since ignored with If-None-Match",
+			method: "GET",
+			etag:   `"v2"`,
+			headers: map[string]string{
+				"If-None-Match":     `"v1"`,
+				"If-Modified-Since": after,
+			},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			w.Header().Set("Content-Type", "application/json")
+
+			if got := CheckNotModified(w, req, tt.etag, modified); got != tt.want {
+				t.Fatalf("CheckNotModified() = %v, want %v", got, tt.want)
+			}
+			if tt.etag != "" && w.Header().Get("ETag") != tt.etag {
+				t.Errorf("ETag = %q, want %q", w.Header().Get("ETag"), tt.etag)
+			}
+			if got := w.Header().Get("Last-Modified"); got != modified.Format(http.TimeFormat) {
+				t.Errorf("Last-Modified = %q", got)
+			}
+			if tt.want {
+				if w.Code != http.StatusNotModified {
+					t.Errorf("status = %d, want 304", w.Code)
+				}
+				if w.Header().Get("Content-Type") != "" {
+					t.Error("Content-Type was not removed from 304 response")
+				}
+			}
+		})
+	}
+}
+
+func TestMatchETag(t *testing.T) {
+	tests := []struct {
+		ifMatch string
+		etag    string
+		want    error
+	}{
+		{`"v1"`, `"v1"`, nil},
+		{`"v0", "v1"`, `"v1"`, nil},
+		{"*", `"v1"`, nil},
+		{`"v2"`, `"v1"`, ErrPreconditionFailed},
+		// If-Match uses strong comparison, weak tags never match
+		{`W/"v1"`, `"v1"`, ErrPreconditionFailed},
+		{`"v1"`, `W/"v1"`, ErrPreconditionFailed},
+		{`W/"v1"`, `W/"v1"`, ErrPreconditionFailed},
+		// resource doesn't exist
+		{"*", "", ErrPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		if err := MatchETag(tt.ifMatch, tt.etag); !errors.Is(err, tt.want) {
+			t.Errorf("MatchETag(%s, %s) = %v, want %v", tt.ifMatch, tt.etag, err, tt.want)
+		}
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{"no conditions", nil, true},
+		{"If-Match", map[string]string{"If-Match": `"v1"`}, true},
+		{"If-Match mismatch", map[string]string{"If-Match": `"v0"`}, false},
+		{"unmodified since", map[string]string{"If-Unmodified-Since": modified.Format(http.TimeFormat)}, true},
+		{"modified since", map[string]string{"If-Unmodified-Since": modified.Add(-time.Second).Format(http.TimeFormat)}, false},
+		{"invalid date", map[string]string{"If-Unmodified-Since": "yesterday"}, true},
+		{
+			name: "If-Unmodified-Since ignored with If-Match",
+			headers: map[string]string{
+				"If-Match":            `"v1"`,
+				"If-Unmodified-Since": modified.Add(-time.Hour).Format(http.TimeFormat),
+			},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+
+			// sub-second part of modification time is ignored, as in Last-Modified
+			if got := CheckPreconditions(w, req, `"v1"`, modified.Add(500*time.Millisecond)); got != tt.want {
+				t.Fatalf("CheckPreconditions() = %v, want %v", got, tt.want)
+			}
+			if !tt.want && w.Code != http.StatusPreconditionFailed {
+				t.Errorf("status = %d, want 412", w.Code)
+			}
+		})
+	}
+}
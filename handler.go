@@ -0,0 +1,353 @@
+package webutility
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// BindError is returned when request can't be bound to handler's input type.
+type BindError struct {
+	Field string
+	Err   error
+}
+
+// Error ...
+func (e *BindError) Error() string {
+	if e.Field == "" {
+		return "invalid request: " + e.Err.Error()
+	}
+	return fmt.Sprintf("invalid value for %s: %s", e.Field, e.Err.Error())
+}
+
+// Unwrap ...
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+// MaxMultipartMemory is the part of multipart/form-data body Bind keeps in memory.
+// The rest of file parts is stored in temporary files.
+var MaxMultipartMemory int64 = 32 << 20
+
+// StatusCoder can be implemented by handler's response type to set response status code.
+type StatusCoder interface {
+	StatusCode() int
+}
+
+var (
+	errorStatusMu sync.RWMutex
+	errorStatus   = []struct {
+		err    error
+		status int
+	}{
+		{ErrNoCredentials, http.StatusUnauthorized},
+		{ErrInvalidCredentials, http.StatusUnauthorized},
+		{ErrTokenRevoked, http.StatusUnauthorized},
+		{ErrSecondFactorRequired, http.StatusUnauthorized},
+		{ErrPermissionDenied, http.StatusForbidden},
+		{ErrPreconditionFailed, http.StatusPreconditionFailed},
+		{sql.ErrNoRows, http.StatusNotFound},
+	}
+)
+
+// RegisterErrorStatus makes Handle respond with status to errors matching err (see errors.Is).
+func RegisterErrorStatus(err error, status int) {
+	errorStatusMu.Lock()
+	defer errorStatusMu.Unlock()
+
+	errorStatus = append(errorStatus, struct {
+		err    error
+		status int
+	}{err, status})
+}
+
+// ErrorStatus returns HTTP status code for err returned from a handler.
+// Errors implementing StatusCoder set their own code.
+func ErrorStatus(err error) int {
+	var p *Problem
+	if errors.As(err, &p) && p.Status != 0 {
+		return p.Status
+	}
+
+	var sc StatusCoder
+	if errors.As(err, &sc) {
+		return sc.StatusCode()
+	}
+
+	var be *BindError
+	if errors.As(err, &be) {
+		return http.StatusBadRequest
+	}
+
+	errorStatusMu.RLock()
+	defer errorStatusMu.RUnlock()
+
+	for _, es := range errorStatus {
+		if errors.Is(err, es.err) {
+			return es.status
+		}
+	}
+
+	return http.StatusInternalServerError
+}
+
+// Handle adapts fn to http.HandlerFunc. Request is bound to a new Req value and fn's result
+// is rendered with Render, so it respects client's Accept header. Returned errors are mapped to
+// status codes with ErrorStatus.
+//
+// Req is bound from:
+//   - JSON body (requests with a body)
+//   - query, form and multipart form values of fields tagged with `form:"name"`, unless the field
+//     is present in JSON body, which takes precedence
+//   - gorilla/mux path variables of fields tagged with `path:"name"`
+//   - request headers of fields tagged with `header:"Name"`, e.g. `header:"If-Match"`
+//   - PaginationParams fields, with GetPaginationParameters
+//   - Filter fields tagged with `filter:"name"`, with ParseFilters
+//
+// Bound value is then checked with Validate; validation errors are answered with 422 Unprocessable Entity.
+// Claims set by middleware are available with ClaimsFromContext(ctx).
+func Handle[Req, Resp any](fn func(ctx context.Context, req Req) (Resp, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var in Req
+		if err := Bind(r, &in); err != nil {
+			handlerError(w, r, err)
+			return
+		}
+		if err := Validate(&in); err != nil {
+			handlerError(w, r, err)
+			return
+		}
+
+		out, err := fn(r.Context(), in)
+		if err != nil {
+			handlerError(w, r, err)
+			return
+		}
+
+		status := http.StatusOK
+		if sc, ok := any(out).(StatusCoder); ok {
+			status = sc.StatusCode()
+		}
+
+		if status == http.StatusNoContent {
+			w.WriteHeader(status)
+			return
+		}
+		Render(w, r, status, out)
+	}
+}
+
+func handlerError(w http.ResponseWriter, r *http.Request, err error) {
+	var ve ValidationErrors
+	if errors.As(err, &ve) {
+		err = ve.Problem()
+	}
+
+	var p *Problem
+	if errors.As(err, &p) {
+		WriteError(w, r, err)
+		return
+	}
+
+	status := ErrorStatus(err)
+	msg := err.Error()
+	if status == http.StatusInternalServerError {
+		// don't leak internals to the client
+		msg = http.StatusText(status)
+	}
+
+	SetContentType(w, "application/json")
+	Error(w, r, status, msg)
+}
+
+// Bind decodes r into v, which must be a pointer to struct. See Handle for binding rules.
+func Bind(r *http.Request, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("webutility: Bind requires a non-nil pointer")
+	}
+
+	// members of JSON body, fields set from them aren't overwritten with form values
+	var inBody map[string]json.RawMessage
+	if r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0 {
+		switch {
+		case r.Header.Get("Content-Type") == "" || HasContentType(r, "application/json"):
+			var body bytes.Buffer
+			if err := DecodeJSON(io.TeeReader(r.Body, &body), v); err != nil {
+				return &BindError{Err: err}
+			}
+			json.Unmarshal(body.Bytes(), &inBody)
+		case HasContentType(r, "application/x-www-form-urlencoded", "multipart/form-data"):
+			// bound through form tags
+		default:
+			return NewProblem(http.StatusUnsupportedMediaType, "unsupported_media_type",
+				"unsupported content type: "+r.Header.Get("Content-Type"))
+		}
+	}
+
+	rv = rv.Elem()
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	vars := mux.Vars(r)
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		fv := rv.Field(i)
+		if !fv.CanSet() {
+			continue
+		}
+
+		switch {
+		case sf.Type == reflect.TypeOf(PaginationParams{}):
+			fv.Set(reflect.ValueOf(GetPaginationParameters(r)))
+
+		case sf.Type == reflect.TypeOf(Filter{}):
+			if name := sf.Tag.Get("filter"); name != "" {
+				fv.Set(reflect.ValueOf(ParseFilters(r, name)))
+			}
+
+		case sf.Tag.Get("path") != "":
+			name := sf.Tag.Get("path")
+			if s, ok := vars[name]; ok {
+				if err := setFromString(fv, []string{s}); err != nil {
+					return &BindError{Field: name, Err: err}
+				}
+			}
+
+		case sf.Tag.Get("header") != "":
+			name := sf.Tag.Get("header")
+			if vals, ok := r.Header[http.CanonicalHeaderKey(name)]; ok {
+				if err := setFromString(fv, vals); err != nil {
+					return &BindError{Field: name, Err: err}
+				}
+			}
+
+		case sf.Tag.Get("form") != "":
+			name := strings.Split(sf.Tag.Get("form"), ",")[0]
+			if jsonMemberPresent(inBody, sf) {
+				continue
+			}
+			if err := parseForm(r); err != nil {
+				return &BindError{Err: err}
+			}
+			if vals, ok := r.Form[name]; ok {
+				if err := setFromString(fv, vals); err != nil {
+					return &BindError{Field: name, Err: err}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// jsonMemberPresent reports whether body has member that encoding/json decodes into field sf.
+func jsonMemberPresent(body map[string]json.RawMessage, sf reflect.StructField) bool {
+	name := strings.Split(sf.Tag.Get("json"), ",")[0]
+	if name == "-" || len(body) == 0 {
+		return false
+	}
+	if name == "" {
+		name = sf.Name
+	}
+	// encoding/json matches member names case-insensitively
+	for k := range body {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForm parses query and body of r, including multipart bodies that r.ParseForm skips.
+func parseForm(r *http.Request) error {
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "multipart/form-data" {
+		return r.ParseMultipartForm(MaxMultipartMemory)
+	}
+	return r.ParseForm()
+}
+
+var (
+	scannerType         = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// setFromString sets fv from string values. Null* types and other sql.Scanner and
+// encoding.TextUnmarshaler implementations are supported.
+func setFromString(fv reflect.Value, vals []string) error {
+	if len(vals) == 0 {
+		return nil
+	}
+	s := vals[0]
+
+	if fv.CanAddr() {
+		if fv.Addr().Type().Implements(scannerType) {
+			return fv.Addr().Interface().(sql.Scanner).Scan(s)
+		}
+		if fv.Addr().Type().Implements(textUnmarshalerType) {
+			return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		}
+	}
+
+	switch fv.Kind() {
+	case reflect.Ptr:
+		p := reflect.New(fv.Type().Elem())
+		if err := setFromString(p.Elem(), vals); err != nil {
+			return err
+		}
+		fv.Set(p)
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Slice:
+		sl := reflect.MakeSlice(fv.Type(), len(vals), len(vals))
+		for i, v := range vals {
+			if err := setFromString(sl.Index(i), []string{v}); err != nil {
+				return err
+			}
+		}
+		fv.Set(sl)
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package webutility
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type bindTarget struct {
+	Name  string   `json:"name" form:"name"`
+	Count int      `json:"count" form:"count"`
+	Tags  []string `json:"-" form:"tag"`
+}
+
+func TestBindForm(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("name", "alice")
+	mw.WriteField("count", "3")
+	mw.WriteField("tag", "a")
+	mw.WriteField("tag", "b")
+	fw, _ := mw.CreateFormFile("file", "f.txt")
+	fw.Write([]byte("content"))
+	mw.Close()
+
+	multipartReq := httptest.NewRequest("POST", "/", &buf)
+	multipartReq.Header.Set("Content-Type", mw.FormDataContentType())
+
+	urlencodedReq := httptest.NewRequest("POST", "/", strings.NewReader("name=alice&count=3&tag=a&tag=b"))
+	urlencodedReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	tests := map[string]*http.Request{
+		"multipart":  multipartReq,
+		"urlencoded": urlencodedReq,
+		"query":      httptest.NewRequest("GET", "/?name=alice&count=3&tag=a&tag=b", nil),
+	}
+	for name, req := range tests {
+		var got bindTarget
+		if err := Bind(req, &got); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got.Name != "alice" || got.Count != 3 || len(got.Tags) != 2 || got.Tags[1] != "b" {
+			t.Errorf("%s: got %+v", name, got)
+		}
+	}
+
+	if f, _, err := multipartReq.FormFile("file"); err != nil {
+		t.Errorf("file part not available after Bind: %v", err)
+	} else {
+		f.Close()
+	}
+}
+
+func TestBindJSON(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"name": "alice", "count": 3}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	var got bindTarget
+	if err := Bind(req, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "alice" || got.Count != 3 {
+		t.Errorf("got %+v", got)
+	}
+
+	req = httptest.NewRequest("POST", "/", strings.NewReader(`{"count": "three"}`))
+	if err := Bind(req, &got); err == nil {
+		t.Error("invalid JSON accepted")
+	}
+}
+
+func TestBindUnsupportedMediaType(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", strings.NewReader("name: alice"))
+	req.Header.Set("Content-Type", "text/yaml")
+
+	var got bindTarget
+	if err := Bind(req, &got); ErrorStatus(err) != http.StatusUnsupportedMediaType {
+		t.Errorf("err = %v, want 415", err)
+	}
+}
+
+func TestBindJSONBodyWinsOverQuery(t *testing.T) {
+	type order struct {
+		Amount int    `json:"amount" form:"amount"`
+		Note   string `json:"note" form:"note"`
+		Dry    bool   `json:"-" form:"dry"`
+	}
+
+	req := httptest.NewRequest("POST", "/?amount=0&note=query&dry=true", strings.NewReader(`{"Amount": 100}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	var got order
+	if err := Bind(req, &got); err != nil {
+		t.Fatal(err)
+	}
+	// fields missing from the body are still bound from the query
+	if got.Amount != 100 || got.Note != "query" || !got.Dry {
+		t.Errorf("got %+v", got)
+	}
+}
@@ -0,0 +1,206 @@
+package webutility
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// DefaultHealthCheckTimeout is timeout of readiness checks registered without one.
+const DefaultHealthCheckTimeout = 2 * time.Second
+
+// HealthCheck returns error if a dependency of the service isn't ready.
+type HealthCheck func(ctx context.Context) error
+
+type namedCheck struct {
+	name    string
+	timeout time.Duration
+	check   HealthCheck
+}
+
+// CheckResult is result of one readiness check.
+type CheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// HealthStatus is response of health endpoints.
+type HealthStatus struct {
+	Status string                 `json:"status"`
+	Uptime string                 `json:"uptime,omitempty"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// BuildInfo is response of the /version endpoint.
+type BuildInfo struct {
+	GoVersion string `json:"goVersion"`
+	Path      string `json:"path,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+// AddHealthCheck registers check that /readyz runs with timeout (DefaultHealthCheckTimeout if zero).
+func (s *Server) AddHealthCheck(name string, timeout time.Duration, check HealthCheck) {
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks = append(s.checks, namedCheck{name, timeout, check})
+}
+
+// HandleHealth registers health endpoints on s.Router:
+//
+//	/healthz  liveness, 200 while the process is serving requests
+//	/readyz   readiness, pings all databases, checks the logger and runs checks added with AddHealthCheck;
+//	          503 if any of them fails or the server is shutting down. Results of individual
+//	          checks are included only if s.HealthDetails is set
+//	/version  build information from runtime/debug
+func (s *Server) HandleHealth() {
+	s.mu.Lock()
+	if s.started.IsZero() {
+		s.started = time.Now()
+	}
+	s.mu.Unlock()
+
+	s.Router.HandleFunc("/healthz", s.livenessHandler).Methods("GET", "HEAD")
+	s.Router.HandleFunc("/readyz", s.readinessHandler).Methods("GET", "HEAD")
+	s.Router.HandleFunc("/version", versionHandler).Methods("GET", "HEAD")
+}
+
+func (s *Server) livenessHandler(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	uptime := time.Since(s.started).Round(time.Second)
+	s.mu.Unlock()
+
+	writeHealth(w, http.StatusOK, HealthStatus{Status: "ok", Uptime: uptime.String()})
+}
+
+func (s *Server) readinessHandler(w http.ResponseWriter, req *http.Request) {
+	checks := s.readinessChecks()
+
+	results := make(map[string]CheckResult, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c namedCheck) {
+			defer wg.Done()
+			res := runCheck(req.Context(), c)
+
+			mu.Lock()
+			results[c.name] = res
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	status, code := "ok", http.StatusOK
+	for _, r := range results {
+		if r.Status != "ok" {
+			status, code = "fail", http.StatusServiceUnavailable
+		}
+	}
+
+	s.mu.Lock()
+	if s.shuttingDown {
+		status, code = "shutting down", http.StatusServiceUnavailable
+	}
+	if !s.HealthDetails {
+		results = nil
+	}
+	s.mu.Unlock()
+
+	writeHealth(w, code, HealthStatus{Status: status, Checks: results})
+}
+
+// readinessChecks returns built-in checks followed by the registered ones.
+func (s *Server) readinessChecks() []namedCheck {
+	var checks []namedCheck
+	for name, db := range s.DBs {
+		db := db
+		checks = append(checks, namedCheck{"db:" + name, DefaultHealthCheckTimeout, db.PingContext})
+	}
+	if s.Logger != nil {
+		checks = append(checks, namedCheck{"logger", DefaultHealthCheckTimeout, func(context.Context) error {
+			return s.Logger.Writable()
+		}})
+	}
+
+	s.mu.Lock()
+	checks = append(checks, s.checks...)
+	s.mu.Unlock()
+
+	return checks
+}
+
+func runCheck(ctx context.Context, c namedCheck) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		// checks that ignore ctx still can't block the probe
+		errc <- c.check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = errors.New("timed out")
+	}
+
+	res := CheckResult{Status: "ok", Duration: time.Since(start).Round(time.Microsecond).String()}
+	if err != nil {
+		res.Status = "fail"
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// ReadBuildInfo returns build information embedded in the binary.
+func ReadBuildInfo() BuildInfo {
+	info := BuildInfo{GoVersion: runtime.Version()}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.Path = bi.Main.Path
+	info.Version = bi.Main.Version
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.time":
+			info.Time = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+func versionHandler(w http.ResponseWriter, req *http.Request) {
+	SetContentType(w, "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ReadBuildInfo())
+}
+
+func writeHealth(w http.ResponseWriter, code int, status HealthStatus) {
+	w.Header().Set("Cache-Control", "no-store")
+	SetContentType(w, "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,39 @@
+package webutility
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadinessDetails(t *testing.T) {
+	s := new(Server)
+	s.AddHealthCheck("cache", time.Second, func(context.Context) error {
+		return errors.New("dial tcp 10.0.0.5:6379: connection refused")
+	})
+
+	w := httptest.NewRecorder()
+	s.readinessHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", w.Code)
+	}
+	if body := w.Body.String(); strings.Contains(body, "cache") || strings.Contains(body, "10.0.0.5") {
+		t.Errorf("check details exposed: %s", body)
+	}
+
+	s.HealthDetails = true
+	w = httptest.NewRecorder()
+	s.readinessHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+	var status HealthStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if res := status.Checks["cache"]; status.Status != "fail" || res.Status != "fail" || res.Error == "" {
+		t.Errorf("got %+v", status)
+	}
+}
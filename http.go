@@ -1,43 +1,84 @@
 package webutility
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
 )
 
-// StatusRecorder ...
+// MaxRecordedBody is the number of response body bytes StatusRecorder keeps.
+var MaxRecordedBody = 64 << 10
+
+// StatusRecorder wraps http.ResponseWriter and records response status, size and body.
+// It implements http.Flusher, http.Hijacker, http.Pusher and io.ReaderFrom by delegating
+// to the wrapped writer, so it doesn't break streaming or connection upgrades.
 type StatusRecorder struct {
-	writer http.ResponseWriter
-	status int
-	size   int
-	data   []byte
+	writer     http.ResponseWriter
+	status     int
+	size       int
+	data       []byte
+	captureAll bool
+	limit      int
 }
 
-// NewStatusRecorder ...
+// NewStatusRecorder returns recorder that keeps bodies of error responses (status >= 400),
+// up to MaxRecordedBody bytes.
 func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
 	return &StatusRecorder{
 		writer: w,
-		status: 0,
-		size:   0,
-		data:   nil,
+		limit:  MaxRecordedBody,
+	}
+}
+
+// NewBodyRecorder returns recorder that keeps bodies of all responses, up to limit bytes.
+// Limit <= 0 means no limit.
+func NewBodyRecorder(w http.ResponseWriter, limit int) *StatusRecorder {
+	return &StatusRecorder{
+		writer:     w,
+		captureAll: true,
+		limit:      limit,
 	}
 }
 
 // WriteHeader is a wrapper http.ResponseWriter interface
 func (r *StatusRecorder) WriteHeader(code int) {
-	r.status = code
+	// informational (1xx) headers may precede the final status, 101 is final
+	if r.status == 0 && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		r.status = code
+	}
 	r.writer.WriteHeader(code)
 }
 
 // Write is a wrapper for http.ResponseWriter interface
 func (r *StatusRecorder) Write(in []byte) (int, error) {
-	r.size = len(in)
-	if r.status >= 400 {
-		r.data = make([]byte, len(in))
-		copy(r.data, in)
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.capture(in)
+	n, err := r.writer.Write(in)
+	r.size += n
+	return n, err
+}
+
+func (r *StatusRecorder) capture(in []byte) {
+	if !r.captureAll && r.status < 400 {
+		return
+	}
+	if r.limit > 0 {
+		room := r.limit - len(r.data)
+		if room <= 0 {
+			return
+		}
+		if room < len(in) {
+			in = in[:room]
+		}
 	}
-	return r.writer.Write(in)
+	r.data = append(r.data, in...)
 }
 
 // Header is a wrapper for http.ResponseWriter interface
@@ -45,17 +86,86 @@ func (r *StatusRecorder) Header() http.Header {
 	return r.writer.Header()
 }
 
-// Status ...
+// Flush implements http.Flusher. It's a no-op if the wrapped writer can't flush,
+// use canFlush to find out whether it can.
+func (r *StatusRecorder) Flush() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if f, ok := r.writer.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (r *StatusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.writer.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("webutility: %T does not implement http.Hijacker", r.writer)
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Push implements http.Pusher.
+func (r *StatusRecorder) Push(target string, opts *http.PushOptions) error {
+	if p, ok := r.writer.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// ReadFrom implements io.ReaderFrom so wrapped writer can use sendfile when body isn't recorded.
+func (r *StatusRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if rf, ok := r.writer.(io.ReaderFrom); ok && !r.captureAll && r.status < 400 {
+		n, err := rf.ReadFrom(src)
+		r.size += int(n)
+		return n, err
+	}
+	// hide ReadFrom from io.Copy to avoid recursion
+	return io.Copy(struct{ io.Writer }{r}, src)
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (r *StatusRecorder) Unwrap() http.ResponseWriter {
+	return r.writer
+}
+
+// canFlush reports whether w can really be flushed. Wrappers that implement http.Flusher
+// regardless of the writer they wrap, like StatusRecorder, are unwrapped to check it.
+func canFlush(w http.ResponseWriter) bool {
+	for {
+		if _, ok := w.(http.Flusher); !ok {
+			return false
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return true
+		}
+		w = u.Unwrap()
+	}
+}
+
+// Status returns response status code. It's 200 if handler wrote the body without calling WriteHeader.
 func (r *StatusRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
 	return r.status
 }
 
-// Size ...
+// Size returns total number of body bytes written.
 func (r *StatusRecorder) Size() int {
 	return r.size
 }
 
-// Size ...
+// Data returns recorded response body.
 func (r *StatusRecorder) Data() []byte {
 	return r.data
 }
@@ -83,6 +193,7 @@ func WriteResponse(w http.ResponseWriter, content []byte) {
 }
 
 // SetAccessControlHeaders set's default headers for an HTTP response.
+// It allows any origin; use CORSPolicy for anything stricter.
 func SetAccessControlHeaders(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, PUT, DELETE, OPTIONS")
@@ -121,13 +232,34 @@ func Created(w http.ResponseWriter, payload interface{}) {
 type weberror struct {
 	Request string `json:"request"`
 	Error   string `json:"error"`
-	//Code    int64  `json:"code"` TODO
+	Code    string `json:"code,omitempty"`
+
+	// Extensions of the problem the error was made from, e.g. "errors" with field errors
+	Extensions map[string]interface{} `json:"-"`
 }
 
-// Error ...
+func (e weberror) MarshalJSON() ([]byte, error) {
+	type plain weberror
+	return marshalWithExtensions(plain(e), e.Extensions)
+}
+
+// Error writes error response with status code. See UseProblemJSON.
 func Error(w http.ResponseWriter, r *http.Request, code int, err string) {
-	werr := weberror{Error: err, Request: r.Method + " " + r.RequestURI}
-	w.WriteHeader(code)
+	ErrorWithCode(w, r, code, "", err)
+}
+
+// ErrorWithCode is like Error but also includes application error code in response.
+func ErrorWithCode(w http.ResponseWriter, r *http.Request, status int, code, err string) {
+	if UseProblemJSON {
+		WriteProblem(w, r, NewProblem(status, code, err))
+		return
+	}
+	writeWebError(w, r, status, code, err, nil)
+}
+
+func writeWebError(w http.ResponseWriter, r *http.Request, status int, code, err string, ext map[string]interface{}) {
+	werr := weberror{Error: err, Request: r.Method + " " + r.RequestURI, Code: code, Extensions: ext}
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(werr)
 }
 
@@ -161,6 +293,12 @@ func Conflict(w http.ResponseWriter, r *http.Request, err string) {
 	Error(w, r, http.StatusConflict, err)
 }
 
+// TooManyRequests ...
+func TooManyRequests(w http.ResponseWriter, r *http.Request, err string) {
+	SetContentType(w, "application/json")
+	Error(w, r, http.StatusTooManyRequests, err)
+}
+
 // InternalServerError ...
 func InternalServerError(w http.ResponseWriter, r *http.Request, err string) {
 	SetContentType(w, "application/json")
@@ -179,6 +317,91 @@ func GetHeader(r *http.Request, key string) string {
 	return r.Header.Get(key)
 }
 
+var (
+	trustedProxiesMu sync.RWMutex
+	trustedProxies   []*net.IPNet
+)
+
+// SetTrustedProxies sets addresses (IPs or CIDR ranges) of reverse proxies in front of the service.
+// ClientIP reads X-Forwarded-For and X-Real-IP headers only from requests that come from them.
+// Calling it without arguments stops trusting proxy headers.
+func SetTrustedProxies(proxies ...string) error {
+	var nets []*net.IPNet
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return fmt.Errorf("invalid proxy address %s", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return fmt.Errorf("invalid proxy address %s", p)
+		}
+		nets = append(nets, n)
+	}
+
+	trustedProxiesMu.Lock()
+	defer trustedProxiesMu.Unlock()
+	trustedProxies = nets
+	return nil
+}
+
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	trustedProxiesMu.RLock()
+	defer trustedProxiesMu.RUnlock()
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns IP address of the client that sent req, without port.
+// Proxy headers are taken into account only for requests from proxies set with SetTrustedProxies,
+// since anyone else can forge them. Client is then the last X-Forwarded-For address that is not
+// a trusted proxy, or X-Real-IP if X-Forwarded-For is missing.
+func ClientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+
+	if xff := req.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				// everything before a malformed entry is untrustworthy
+				return host
+			}
+			if !isTrustedProxy(hop) || i == 0 {
+				return hop
+			}
+		}
+	}
+
+	if ip := strings.TrimSpace(req.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+		return ip
+	}
+	return host
+}
+
 func ClientUTCOffset(req *http.Request) int64 {
 	return StringToInt64(GetHeader(req, "X-Timezone-Offset"))
 }
@@ -0,0 +1,149 @@
+package webutility
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// plainWriter hides optional interfaces of the writer it wraps.
+type plainWriter struct {
+	http.ResponseWriter
+}
+
+func TestStatusRecorderStatus(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w http.ResponseWriter)
+		want  int
+	}{
+		{"implicit", func(w http.ResponseWriter) { w.Write([]byte("ok")) }, http.StatusOK},
+		{"explicit", func(w http.ResponseWriter) { w.WriteHeader(http.StatusCreated) }, http.StatusCreated},
+		{"first wins", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusNotFound)
+			w.WriteHeader(http.StatusOK)
+		}, http.StatusNotFound},
+		{"early hints", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusEarlyHints)
+			w.Write([]byte("ok"))
+		}, http.StatusOK},
+		{"early hints then status", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusEarlyHints)
+			w.WriteHeader(http.StatusAccepted)
+		}, http.StatusAccepted},
+		{"early hints then flush", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusEarlyHints)
+			w.(http.Flusher).Flush()
+		}, http.StatusOK},
+		{"switching protocols", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusSwitchingProtocols)
+		}, http.StatusSwitchingProtocols},
+		{"nothing written", func(w http.ResponseWriter) {}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		rec := NewStatusRecorder(httptest.NewRecorder())
+		tt.write(rec)
+		if got := rec.Status(); got != tt.want {
+			t.Errorf("%s: Status() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStatusRecorderBody(t *testing.T) {
+	limit := MaxRecordedBody
+	MaxRecordedBody = 4
+	defer func() { MaxRecordedBody = limit }()
+
+	rec := NewStatusRecorder(httptest.NewRecorder())
+	rec.Write([]byte("success"))
+	if len(rec.Data()) != 0 {
+		t.Errorf("body of successful response recorded: %q", rec.Data())
+	}
+
+	rec = NewStatusRecorder(httptest.NewRecorder())
+	rec.WriteHeader(http.StatusBadRequest)
+	rec.Write([]byte("bad "))
+	rec.Write([]byte("request"))
+	if got := string(rec.Data()); got != "bad " {
+		t.Errorf("Data() = %q, want %q", got, "bad ")
+	}
+	if rec.Size() != len("bad request") {
+		t.Errorf("Size() = %d, want %d", rec.Size(), len("bad request"))
+	}
+
+	w := httptest.NewRecorder()
+	rec = NewBodyRecorder(w, 0)
+	n, err := rec.ReadFrom(strings.NewReader("streamed body"))
+	if err != nil || n != int64(len("streamed body")) {
+		t.Fatalf("ReadFrom = %d, %v", n, err)
+	}
+	if string(rec.Data()) != "streamed body" || w.Body.String() != "streamed body" {
+		t.Errorf("Data() = %q, body = %q", rec.Data(), w.Body.String())
+	}
+}
+
+func TestCanFlush(t *testing.T) {
+	flushing := httptest.NewRecorder()
+	plain := plainWriter{flushing}
+
+	tests := []struct {
+		name string
+		w    http.ResponseWriter
+		want bool
+	}{
+		{"flusher", flushing, true},
+		{"plain", plain, false},
+		{"recorder over flusher", NewStatusRecorder(flushing), true},
+		{"recorder over plain", NewStatusRecorder(plain), false},
+		{"nested recorders over plain", NewStatusRecorder(NewStatusRecorder(plain)), false},
+	}
+	for _, tt := range tests {
+		if got := canFlush(tt.w); got != tt.want {
+			t.Errorf("%s: canFlush() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	if err := SetTrustedProxies("10.0.0.1", "192.168.0.0/16"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetTrustedProxies()
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		realIP string
+		want   string
+	}{
+		{"direct", "203.0.113.7:5000", "", "", "203.0.113.7"},
+		{"untrusted sender", "203.0.113.7:5000", "198.51.100.1", "198.51.100.2", "203.0.113.7"},
+		{"proxy", "10.0.0.1:5000", "198.51.100.1", "", "198.51.100.1"},
+		{"proxy chain", "10.0.0.1:5000", "198.51.100.1, 192.168.1.1", "", "198.51.100.1"},
+		{"forged entry before client", "10.0.0.1:5000", "1.2.3.4, 198.51.100.1, 192.168.1.1", "", "198.51.100.1"},
+		{"only proxies", "10.0.0.1:5000", "192.168.1.2, 192.168.1.1", "", "192.168.1.2"},
+		{"malformed", "10.0.0.1:5000", "198.51.100.1, junk", "", "10.0.0.1"},
+		{"real ip", "192.168.3.3:5000", "", "198.51.100.9", "198.51.100.9"},
+		{"proxy without headers", "10.0.0.1:5000", "", "", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if tt.realIP != "" {
+			req.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := ClientIP(req); got != tt.want {
+			t.Errorf("%s: ClientIP() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if err := SetTrustedProxies("10.0.0.300"); err == nil {
+		t.Error("invalid proxy address accepted")
+	}
+}
@@ -0,0 +1,315 @@
+package webutility
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodEdDSA implements the EdDSA (Ed25519) signing method which jwt-go lacks.
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+// SigningKey is a single JWT key identified by the 'kid' token header.
+// Private is used for signing and may be nil for verify-only keys.
+// Public is used for verification.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  interface{}
+
+	// NotBefore is the time the key becomes the active signing key.
+	NotBefore time.Time
+	// ExpiresAt is the time after which tokens signed with the key are rejected.
+	// Zero value means the key never expires.
+	ExpiresAt time.Time
+}
+
+// NewHMACKey returns HS256 key. The same secret is used for signing and verification.
+func NewHMACKey(id string, secret []byte) *SigningKey {
+	return &SigningKey{
+		ID:      id,
+		Method:  jwt.SigningMethodHS256,
+		Private: secret,
+		Public:  secret,
+	}
+}
+
+// NewRSAKey returns RS256 key. If priv is nil the key can only verify tokens.
+func NewRSAKey(id string, priv *rsa.PrivateKey, pub *rsa.PublicKey) *SigningKey {
+	k := &SigningKey{
+		ID:     id,
+		Method: jwt.SigningMethodRS256,
+		Public: pub,
+	}
+	if priv != nil {
+		k.Private = priv
+		k.Public = &priv.PublicKey
+	}
+	return k
+}
+
+// NewECDSAKey returns ES256, ES384 or ES512 key, depending on the curve.
+// If priv is nil the key can only verify tokens.
+func NewECDSAKey(id string, priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey) (*SigningKey, error) {
+	if priv != nil {
+		pub = &priv.PublicKey
+	}
+	if pub == nil {
+		return nil, errors.New("webutility: missing ECDSA key")
+	}
+
+	k := &SigningKey{ID: id, Public: pub}
+	if priv != nil {
+		k.Private = priv
+	}
+
+	switch pub.Curve {
+	case elliptic.P256():
+		k.Method = jwt.SigningMethodES256
+	case elliptic.P384():
+		k.Method = jwt.SigningMethodES384
+	case elliptic.P521():
+		k.Method = jwt.SigningMethodES512
+	default:
+		return nil, errors.New("webutility: unsupported ECDSA curve")
+	}
+
+	return k, nil
+}
+
+// NewEd25519Key returns EdDSA key. If priv is nil the key can only verify tokens.
+func NewEd25519Key(id string, priv ed25519.PrivateKey, pub ed25519.PublicKey) *SigningKey {
+	k := &SigningKey{
+		ID:     id,
+		Method: SigningMethodEdDSA,
+		Public: pub,
+	}
+	if priv != nil {
+		k.Private = priv
+		k.Public = priv.Public()
+	}
+	return k
+}
+
+func (k *SigningKey) canSign(now time.Time) bool {
+	return k.Private != nil && !now.Before(k.NotBefore) && !k.expired(now)
+}
+
+func (k *SigningKey) expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && now.After(k.ExpiresAt)
+}
+
+// KeySet holds all keys that are currently accepted for JWT verification.
+// The signing key is the most recently activated key that has a private part.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys map[string]*SigningKey
+}
+
+// NewKeySet ...
+func NewKeySet(keys ...*SigningKey) *KeySet {
+	ks := &KeySet{
+		keys: make(map[string]*SigningKey),
+	}
+	for _, k := range keys {
+		ks.keys[k.ID] = k
+	}
+	return ks
+}
+
+// Add adds k to the set, replacing any existing key with the same ID.
+func (ks *KeySet) Add(k *SigningKey) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys[k.ID] = k
+}
+
+// Remove ...
+func (ks *KeySet) Remove(id string) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	delete(ks.keys, id)
+}
+
+// Rotate schedules next to become the signing key at next.NotBefore (now if not set).
+// Keys that are signing until then keep validating tokens for the grace period after that.
+func (ks *KeySet) Rotate(next *SigningKey, grace time.Duration) {
+	if next.NotBefore.IsZero() {
+		next.NotBefore = time.Now()
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	retireAt := next.NotBefore.Add(grace)
+	for _, k := range ks.keys {
+		if k.Private != nil && k.ExpiresAt.IsZero() && k.NotBefore.Before(next.NotBefore) {
+			k.ExpiresAt = retireAt
+		}
+	}
+	ks.keys[next.ID] = next
+}
+
+// Prune removes all expired keys from the set.
+func (ks *KeySet) Prune() {
+	now := time.Now()
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	for id, k := range ks.keys {
+		if k.expired(now) {
+			delete(ks.keys, id)
+		}
+	}
+}
+
+// SigningKey returns currently active signing key.
+func (ks *KeySet) SigningKey() (*SigningKey, error) {
+	now := time.Now()
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	var active *SigningKey
+	for _, k := range ks.keys {
+		if !k.canSign(now) {
+			continue
+		}
+		if active == nil || k.NotBefore.After(active.NotBefore) {
+			active = k
+		}
+	}
+
+	if active == nil {
+		return nil, errors.New("webutility: no active signing key")
+	}
+	return active, nil
+}
+
+// VerificationKey returns the key with ID kid if it hasn't expired.
+func (ks *KeySet) VerificationKey(kid string) (*SigningKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	k, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("webutility: unknown key id: %s", kid)
+	}
+	if k.expired(time.Now()) {
+		return nil, fmt.Errorf("webutility: key %s has expired", kid)
+	}
+
+	return k, nil
+}
+
+// PublicKeys returns verify-only copies of all keys in the set.
+// Result can be handed to other services without exposing signing secrets.
+// HMAC keys are skipped since their verification key is the secret itself.
+func (ks *KeySet) PublicKeys() *KeySet {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	pub := NewKeySet()
+	for _, k := range ks.keys {
+		if _, ok := k.Method.(*jwt.SigningMethodHMAC); ok {
+			continue
+		}
+		pub.keys[k.ID] = &SigningKey{
+			ID:        k.ID,
+			Method:    k.Method,
+			Public:    k.Public,
+			NotBefore: k.NotBefore,
+			ExpiresAt: k.ExpiresAt,
+		}
+	}
+
+	return pub
+}
+
+// Sign signs claims with the active signing key and sets 'kid' header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	k, err := ks.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(k.Method, claims)
+	token.Header["kid"] = k.ID
+
+	return token.SignedString(k.Private)
+}
+
+// Keyfunc is a jwt.Keyfunc that selects verification key by 'kid' header.
+// Tokens signed with an algorithm other than the key's are rejected.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	k, err := ks.VerificationKey(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Method.Alg() != k.Method.Alg() {
+		return nil, fmt.Errorf("webutility: unexpected signing method: %s", token.Method.Alg())
+	}
+
+	return k.Public, nil
+}
+
+// signingMethodEdDSA ...
+type signingMethodEdDSA struct{}
+
+// Alg ...
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Sign ...
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	sig, err := priv.Sign(nil, []byte(signingString), crypto.Hash(0))
+	if err != nil {
+		return "", err
+	}
+
+	return jwt.EncodeSegment(sig), nil
+}
+
+// Verify ...
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+
+	return nil
+}
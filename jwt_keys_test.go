@@ -0,0 +1,194 @@
+package webutility
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// useKeys makes ks the package key set for the duration of the test.
+func useKeys(t *testing.T, ks *KeySet) {
+	t.Helper()
+	issuer, keys := _issuer, _keys
+	InitJWTKeys("test", ks)
+	t.Cleanup(func() {
+		_issuer, _keys = issuer, keys
+	})
+}
+
+func testRSAKey(t *testing.T, id string) *SigningKey {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRSAKey(id, priv, nil)
+}
+
+func TestKeySetSignAndVerify(t *testing.T) {
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := NewECDSAKey("ec", ec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []*SigningKey{NewHMACKey("hs", []byte("secret")), testRSAKey(t, "rs"), ecKey, NewEd25519Key("ed", ed, nil)} {
+		t.Run(k.Method.Alg(), func(t *testing.T) {
+			useKeys(t, NewKeySet(k))
+
+			claims, err := NewAuthToken("alice", "admin", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseAuthToken(claims.Token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Username != "alice" {
+				t.Errorf("username = %q, want alice", got.Username)
+			}
+		})
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	old := testRSAKey(t, "old")
+	ks := NewKeySet(old)
+	useKeys(t, ks)
+
+	before, err := NewAuthToken("alice", "admin", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := testRSAKey(t, "new")
+	ks.Rotate(next, time.Hour)
+
+	if k, err := ks.SigningKey(); err != nil || k.ID != "new" {
+		t.Fatalf("signing key = %v, %v, want new", k, err)
+	}
+	if _, err = ParseAuthToken(before.Token); err != nil {
+		t.Errorf("token signed with old key rejected during grace period: %v", err)
+	}
+
+	after, err := NewAuthToken("alice", "admin", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseAuthToken(after.Token); err != nil {
+		t.Errorf("token signed with new key rejected: %v", err)
+	}
+
+	// grace period is over
+	old.ExpiresAt = time.Now().Add(-time.Second)
+	if _, err = ParseAuthToken(before.Token); err == nil {
+		t.Error("token signed with retired key accepted")
+	}
+
+	ks.Prune()
+	if _, err = ks.VerificationKey("old"); err == nil {
+		t.Error("retired key not pruned")
+	}
+	if _, err = ParseAuthToken(before.Token); err == nil {
+		t.Error("token signed with pruned key accepted")
+	}
+}
+
+func TestKeyfuncRejectsAlgorithmMismatch(t *testing.T) {
+	rs := testRSAKey(t, "rs")
+	useKeys(t, NewKeySet(rs))
+
+	claims := TokenClaims{Username: "mallory", RoleName: "admin"}
+	claims.Issuer = "test"
+	claims.ExpiresAt = time.Now().Add(time.Hour).Unix()
+
+	// HS256 signed with the public key, which attackers may know
+	der, err := x509.MarshalPKIXPublicKey(rs.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hs.Header["kid"] = "rs"
+	hsToken, err := hs.SignedString(pubPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	none.Header["kid"] = "rs"
+	noneToken, err := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tok := range map[string]string{"HS256": hsToken, "none": noneToken} {
+		if _, err := ParseAuthToken(tok); err == nil {
+			t.Errorf("%s token accepted for RS256 key", name)
+		}
+	}
+}
+
+func TestKeyfuncUnknownKid(t *testing.T) {
+	signer := NewKeySet(NewHMACKey("a", []byte("secret")))
+	verifier := NewKeySet(NewHMACKey("b", []byte("secret")))
+
+	tok, err := signer.Sign(jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = jwt.Parse(tok, verifier.Keyfunc); err == nil {
+		t.Error("token with unknown kid accepted")
+	}
+}
+
+func TestLegacyTokenWithoutKid(t *testing.T) {
+	secret := []byte("legacy secret")
+
+	// tokens issued before key rotation support have no kid header
+	claims := TokenClaims{Username: "alice", RoleName: "admin"}
+	claims.Issuer = "test"
+	claims.ExpiresAt = time.Now().Add(time.Hour).Unix()
+	tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks := NewKeySet(NewHMACKey("", secret))
+	useKeys(t, ks)
+	ks.Rotate(testRSAKey(t, "rs"), time.Hour)
+
+	got, err := ParseAuthToken(tok)
+	if err != nil {
+		t.Fatalf("legacy token rejected: %v", err)
+	}
+	if got.Username != "alice" {
+		t.Errorf("username = %q, want alice", got.Username)
+	}
+
+	// legacy key must still refuse tokens claiming another algorithm
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	noneToken, err := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseAuthToken(noneToken); err == nil {
+		t.Error("unsigned legacy token accepted")
+	}
+}
@@ -51,13 +51,13 @@ func (l *Logger) LogHTTPResponse(data []byte, status int, startTime time.Time) s
 
 // CombineHTTPLogs ...
 func (l *Logger) CombineHTTPLogs(in string, out string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
 	if l.outputFile == nil {
 		return
 	}
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
 	msg := in + out
 	if l.shouldSplit(len(msg)) {
 		l.split()
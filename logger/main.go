@@ -65,13 +65,13 @@ func New(name, dir string, maxFileSize int64) (logger *Logger, err error) {
 
 // Log ...
 func (l *Logger) Log(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
 	if l.outputFile == nil {
 		return
 	}
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
 	msg := fmt.Sprintf(format, v...)
 	s := time.Now().Format(dateTimeFormat) + ": " + msg + "\n"
 	if l.shouldSplit(len(s)) {
@@ -88,13 +88,13 @@ func (l *Logger) Print(format string, v ...interface{}) {
 
 // Trace ...
 func (l *Logger) Trace(format string, v ...interface{}) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
 	if l.outputFile == nil {
 		return ""
 	}
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
 	s := getTrace(format, v...) + "\n"
 
 	if l.shouldSplit(len(s)) {
@@ -119,11 +119,34 @@ func (l *Logger) PrintAndTrace(format string, v ...interface{}) {
 
 // Close ...
 func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
 	if l.outputFile == nil {
 		return nil
 	}
 
-	return l.outputFile.Close()
+	// later writes are dropped instead of failing on closed file
+	f := l.outputFile
+	l.outputFile = nil
+	return f.Close()
+}
+
+// Writable returns error if log file is closed or no longer accessible.
+func (l *Logger) Writable() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.outputFile == nil {
+		return fmt.Errorf("log file is closed")
+	}
+	if _, err := l.outputFile.Stat(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(l.outputFile.Name()); err != nil {
+		return err
+	}
+	return nil
 }
 
 // GetOutDir ...
@@ -151,7 +174,10 @@ func (l *Logger) split() error {
 }
 
 func (l *Logger) shouldSplit(nextEntrySize int) bool {
-	stats, _ := l.outputFile.Stat()
+	stats, err := l.outputFile.Stat()
+	if err != nil {
+		return false
+	}
 	return int64(nextEntrySize) >= (l.maxFileSize - stats.Size())
 }
 
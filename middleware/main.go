@@ -22,6 +22,26 @@ func AuthUserAndLog(roles string, h http.HandlerFunc) http.HandlerFunc {
 	return SetAccessControlHeaders(IgnoreOptionsRequests(ParseForm(LogHTTP(Auth(roles, h)))))
 }
 
+func AuthPermission(perm string, h http.HandlerFunc) http.HandlerFunc {
+	return SetAccessControlHeaders(IgnoreOptionsRequests(ParseForm(RequirePermission(perm, h))))
+}
+
+func AuthPermissionAndLog(perm string, h http.HandlerFunc) http.HandlerFunc {
+	return SetAccessControlHeaders(IgnoreOptionsRequests(ParseForm(LogHTTP(RequirePermission(perm, h)))))
+}
+
+func AuthUserWith(a web.Authenticator, roles string, h http.HandlerFunc) http.HandlerFunc {
+	return SetAccessControlHeaders(IgnoreOptionsRequests(ParseForm(AuthWith(a, Auth(roles, h)))))
+}
+
+func AuthUserWithAndLog(a web.Authenticator, roles string, h http.HandlerFunc) http.HandlerFunc {
+	return SetAccessControlHeaders(IgnoreOptionsRequests(ParseForm(AuthWith(a, LogHTTP(Auth(roles, h))))))
+}
+
+func SecondFactorUser(h http.HandlerFunc) http.HandlerFunc {
+	return SetAccessControlHeaders(IgnoreOptionsRequests(ParseForm(AuthSecondFactor(h))))
+}
+
 func LogTraffic(h http.HandlerFunc) http.HandlerFunc {
 	return SetAccessControlHeaders(IgnoreOptionsRequests(ParseForm(LogHTTP(h))))
 }
@@ -10,18 +10,36 @@ import (
 
 var httpLogger *logger.Logger
 
+// SetAccessControlHeaders sets CORS headers according to policy set with web.SetCORSPolicy,
+// unless they were already set by web.CORSPolicy.Handler (e.g. Server.CORS).
 func SetAccessControlHeaders(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		web.SetAccessControlHeaders(w)
+		if !web.CORSHandled(req) {
+			web.GetCORSPolicy().SetHeaders(w, req)
+		}
+
+		h(w, req)
+	}
+}
+
+// CORS is like SetAccessControlHeaders with policy p, but it also answers preflight requests.
+func CORS(p *web.CORSPolicy, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if p.SetHeaders(w, req) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
 
 		h(w, req)
 	}
 }
 
-// IgnoreOptionsRequests ...
+// IgnoreOptionsRequests answers CORS preflight requests with 204 No Content.
+// Other OPTIONS requests are passed on to h.
 func IgnoreOptionsRequests(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		if req.Method == http.MethodOptions {
+		if web.IsPreflight(req) {
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
@@ -81,8 +99,12 @@ func LogHTTP(hfunc http.HandlerFunc) http.HandlerFunc {
 
 		t1 := time.Now()
 
-		claims, _ := web.GetTokenClaims(req)
-		in := httpLogger.LogHTTPRequest(req, claims.Username)
+		// parsed claims are passed on so Auth doesn't have to parse the token again
+		claims, err := web.RequestClaims(req)
+		if err == nil {
+			req = req.WithContext(web.ContextWithClaims(req.Context(), claims))
+		}
+		in := httpLogger.LogHTTPRequest(web.RedactCredentials(req), claims.Username)
 
 		rec := web.NewStatusRecorder(w)
 
@@ -97,11 +119,74 @@ func LogHTTP(hfunc http.HandlerFunc) http.HandlerFunc {
 // Auth ...
 func Auth(roles string, h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		if _, err := web.AuthCheck(req, roles); err != nil {
+		claims, err := web.AuthCheck(req, roles)
+		if err != nil {
+			web.Unauthorized(w, req, err.Error())
+			return
+		}
+
+		h(w, req.WithContext(web.ContextWithClaims(req.Context(), claims)))
+	}
+}
+
+// RequirePermission ...
+func RequirePermission(perm string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		claims, err := web.PermissionCheck(req, perm)
+		if err != nil {
+			if err == web.ErrPermissionDenied {
+				web.Forbidden(w, req, err.Error())
+			} else {
+				web.Unauthorized(w, req, err.Error())
+			}
+			return
+		}
+
+		h(w, req.WithContext(web.ContextWithClaims(req.Context(), claims)))
+	}
+}
+
+// AuthWith authenticates request with a and passes resulting claims to h through request context.
+// It's meant to be wrapped around Auth or RequirePermission to enable other authentication schemes.
+func AuthWith(a web.Authenticator, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		claims, err := a.Authenticate(req)
+		if err != nil {
 			web.Unauthorized(w, req, err.Error())
 			return
 		}
 
+		h(w, req.WithContext(web.ContextWithClaims(req.Context(), claims)))
+	}
+}
+
+// AuthSecondFactor only lets through requests with partial tokens issued by web.NewPartialAuthToken.
+// Use it for endpoints that verify the second factor.
+func AuthSecondFactor(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		claims, err := web.SecondFactorCheck(req)
+		if err != nil {
+			web.Unauthorized(w, req, err.Error())
+			return
+		}
+
+		h(w, req.WithContext(web.ContextWithClaims(req.Context(), claims)))
+	}
+}
+
+// Compress compresses responses of h, see web.Compress. Put it outside of LogHTTP.
+func Compress(h http.HandlerFunc) http.HandlerFunc {
+	return web.Compress(h).ServeHTTP
+}
+
+// RequireContentType responds with 415 Unsupported Media Type if request body is not one of types.
+func RequireContentType(types []string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !web.HasContentType(req, types...) {
+			web.UnsupportedMediaType(w, req, "unsupported content type: "+req.Header.Get("Content-Type"))
+			return
+		}
+
 		h(w, req)
 	}
 }
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	web "git.to-net.rs/marko.tikvic/webutility"
+	"git.to-net.rs/marko.tikvic/webutility/logger"
+)
+
+func useHTTPLogger(t *testing.T) {
+	t.Helper()
+	l, err := logger.New("http", t.TempDir(), logger.MaxLogSize1MB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := httpLogger
+	httpLogger = l
+	t.Cleanup(func() {
+		httpLogger = prev
+		l.Close()
+	})
+}
+
+func TestClaimsInContext(t *testing.T) {
+	useHTTPLogger(t)
+
+	tok, err := web.NewAuthToken("alice", "admin", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got *web.TokenClaims
+	capture := func(w http.ResponseWriter, req *http.Request) {
+		got, _ = web.ClaimsFromContext(req.Context())
+	}
+
+	tests := map[string]http.HandlerFunc{
+		"LogHTTP":      LogHTTP(capture),
+		"Auth":         Auth("admin", capture),
+		"LogHTTP+Auth": LogHTTP(Auth("admin", capture)),
+	}
+	for name, h := range tests {
+		got = nil
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+tok.Token)
+		h(httptest.NewRecorder(), req)
+
+		if got == nil || got.Username != "alice" || got.Id != tok.Id {
+			t.Errorf("%s: claims in context = %+v", name, got)
+		}
+	}
+
+	// requests without valid token have no claims in context
+	got = nil
+	LogHTTP(capture)(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if got != nil {
+		t.Errorf("anonymous request: claims in context = %+v", got)
+	}
+}
+
+func TestCORSHandlerReplacesGlobalPolicy(t *testing.T) {
+	// global policy allows any origin, as set by SetAccessControlHeaders by default
+	web.SetCORSPolicy(nil)
+
+	p := &web.CORSPolicy{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{"GET"},
+	}
+	h := p.Handler(Headers(func(w http.ResponseWriter, req *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Origin", "https://evil.com")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("disallowed origin: Allow-Origin = %q", got)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Allow-Origin = %q", got)
+	}
+	if got := w.Header().Values("Vary"); len(got) != 1 {
+		t.Errorf("Vary = %q, want single Origin", got)
+	}
+}
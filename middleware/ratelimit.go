@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+
+	web "git.to-net.rs/marko.tikvic/webutility"
+)
+
+// RateLimitKey derives rate limiting key from a request.
+type RateLimitKey func(req *http.Request) string
+
+// KeyByIP ...
+func KeyByIP(req *http.Request) string {
+	return "ip:" + web.ClientIP(req)
+}
+
+// KeyByUsername uses username from token claims. Anonymous requests are limited by IP.
+func KeyByUsername(req *http.Request) string {
+	if claims, err := web.RequestClaims(req); err == nil && claims.Username != "" {
+		return "user:" + claims.Username
+	}
+	return KeyByIP(req)
+}
+
+// RateLimit rejects requests over the limit with 429 Too Many Requests.
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers are set on every response,
+// Retry-After on rejected ones.
+func RateLimit(l web.Limiter, key RateLimitKey, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		res, err := l.Allow(key(req))
+		if err != nil {
+			// don't lock everyone out if the store is unavailable
+			if httpLogger != nil {
+				httpLogger.Log("rate limit: %s", err.Error())
+			}
+			h(w, req)
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", fmt.Sprintf("%d", res.Limit))
+		w.Header().Set("RateLimit-Remaining", fmt.Sprintf("%d", res.Remaining))
+		w.Header().Set("RateLimit-Reset", fmt.Sprintf("%d", ceilSeconds(res.Reset.Seconds())))
+
+		if !res.Allowed {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", ceilSeconds(res.RetryAfter.Seconds())))
+			web.TooManyRequests(w, req, "rate limit exceeded")
+			return
+		}
+
+		h(w, req)
+	}
+}
+
+func ceilSeconds(s float64) int64 {
+	return int64(math.Ceil(s))
+}
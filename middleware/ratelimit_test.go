@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	web "git.to-net.rs/marko.tikvic/webutility"
+)
+
+func TestRateLimit(t *testing.T) {
+	h := RateLimit(web.NewTokenBucket(1, time.Minute, 1), KeyByIP, func(w http.ResponseWriter, req *http.Request) {})
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "1" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("first request: status = %d, headers = %v", w.Code, w.Header())
+	}
+	if w.Header().Get("Retry-After") != "" {
+		t.Error("Retry-After set on allowed request")
+	}
+
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("second request: status = %d, Retry-After = %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestKeyByIPBehindProxy(t *testing.T) {
+	if err := web.SetTrustedProxies("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	defer web.SetTrustedProxies()
+
+	h := RateLimit(web.NewTokenBucket(1, time.Minute, 1), KeyByIP, func(w http.ResponseWriter, req *http.Request) {})
+	for _, client := range []string{"198.51.100.1", "198.51.100.2"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:4000"
+		req.Header.Set("X-Forwarded-For", client)
+		w := httptest.NewRecorder()
+		h(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, clients behind proxy share a limit", client, w.Code)
+		}
+	}
+}
@@ -0,0 +1,568 @@
+package webutility
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// OIDCIdentity is the verified identity from provider's ID token.
+type OIDCIdentity struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	// Claims holds all ID token claims.
+	Claims map[string]interface{}
+}
+
+// OIDCUser is the local user an external identity is mapped to.
+type OIDCUser struct {
+	Username string
+	RoleName string
+	RoleID   int64
+}
+
+// Defaults of OIDCProvider limits.
+const (
+	DefaultOIDCLoginTimeout = 10 * time.Minute
+	DefaultMaxPendingLogins = 10000
+)
+
+// OIDCProvider implements OpenID Connect authorization code flow with PKCE.
+// After successful login the user receives a token pair issued by IssueTokenPair.
+//
+// Pending logins are kept in memory, so callback must reach the same instance that handled login.
+type OIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// Map converts external identity to local user. Returned error denies the login.
+	Map func(id OIDCIdentity) (OIDCUser, error)
+
+	// OnLogin writes response after successful login. Default responds with token pair as JSON.
+	OnLogin func(w http.ResponseWriter, req *http.Request, pair TokenPair)
+
+	// Client is used for requests to provider. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	// LoginTimeout is the time user has to finish login at provider. Defaults to DefaultOIDCLoginTimeout.
+	LoginTimeout time.Duration
+
+	// MaxPendingLogins limits logins waiting for callback, since anyone can start one.
+	// New logins are refused with 429 Too Many Requests while the limit is reached.
+	MaxPendingLogins int
+
+	mu          sync.Mutex
+	config      *oidcConfig
+	keys        *KeySet
+	keysFetched time.Time
+	pending     map[string]oidcLogin
+}
+
+type oidcConfig struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcLogin struct {
+	verifier string
+	nonce    string
+	expires  time.Time
+}
+
+// NewOIDCProvider ...
+func NewOIDCProvider(issuer, clientID, clientSecret, redirectURL string, mapper func(OIDCIdentity) (OIDCUser, error)) *OIDCProvider {
+	return &OIDCProvider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "profile", "email"},
+		Map:          mapper,
+		LoginTimeout: DefaultOIDCLoginTimeout,
+
+		MaxPendingLogins: DefaultMaxPendingLogins,
+	}
+}
+
+// LoginHandler redirects user to provider's authorization endpoint.
+func (p *OIDCProvider) LoginHandler(w http.ResponseWriter, req *http.Request) {
+	cfg, err := p.discover()
+	if err != nil {
+		InternalServerError(w, req, err.Error())
+		return
+	}
+
+	state, err := randomToken(16)
+	if err != nil {
+		InternalServerError(w, req, err.Error())
+		return
+	}
+	nonce, err := randomToken(16)
+	if err != nil {
+		InternalServerError(w, req, err.Error())
+		return
+	}
+	verifier, err := randomToken(32)
+	if err != nil {
+		InternalServerError(w, req, err.Error())
+		return
+	}
+
+	timeout := p.LoginTimeout
+	if timeout <= 0 {
+		timeout = DefaultOIDCLoginTimeout
+	}
+
+	p.mu.Lock()
+	if p.pending == nil {
+		p.pending = make(map[string]oidcLogin)
+	}
+	now := time.Now()
+	for k, v := range p.pending {
+		if now.After(v.expires) {
+			delete(p.pending, k)
+		}
+	}
+	if p.MaxPendingLogins > 0 && len(p.pending) >= p.MaxPendingLogins {
+		p.mu.Unlock()
+		TooManyRequests(w, req, "too many pending logins")
+		return
+	}
+	p.pending[state] = oidcLogin{
+		verifier: verifier,
+		nonce:    nonce,
+		expires:  now.Add(timeout),
+	}
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(verifier))
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.ClientID)
+	params.Set("redirect_uri", p.RedirectURL)
+	params.Set("scope", strings.Join(p.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(cfg.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, req, cfg.AuthorizationEndpoint+sep+params.Encode(), http.StatusFound)
+}
+
+// CallbackHandler exchanges authorization code for ID token, verifies it and issues local tokens.
+func (p *OIDCProvider) CallbackHandler(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	if e := q.Get("error"); e != "" {
+		Unauthorized(w, req, fmt.Sprintf("login failed: %s %s", e, q.Get("error_description")))
+		return
+	}
+
+	state := q.Get("state")
+	p.mu.Lock()
+	login, ok := p.pending[state]
+	delete(p.pending, state)
+	p.mu.Unlock()
+	if !ok || time.Now().After(login.expires) {
+		BadRequest(w, req, "invalid or expired login state")
+		return
+	}
+
+	code := q.Get("code")
+	if code == "" {
+		BadRequest(w, req, "missing authorization code")
+		return
+	}
+
+	rawIDToken, err := p.exchange(code, login.verifier)
+	if err != nil {
+		Unauthorized(w, req, err.Error())
+		return
+	}
+
+	id, err := p.VerifyIDToken(rawIDToken, login.nonce)
+	if err != nil {
+		Unauthorized(w, req, err.Error())
+		return
+	}
+
+	if p.Map == nil {
+		InternalServerError(w, req, "identity mapping is not configured")
+		return
+	}
+	user, err := p.Map(id)
+	if err != nil {
+		Forbidden(w, req, err.Error())
+		return
+	}
+
+	pair, err := IssueTokenPair(user.Username, user.RoleName, user.RoleID)
+	if err != nil {
+		InternalServerError(w, req, err.Error())
+		return
+	}
+
+	if p.OnLogin != nil {
+		p.OnLogin(w, req, pair)
+		return
+	}
+	OK(w, pair)
+}
+
+// VerifyIDToken validates signature of raw ID token against provider's JWKS
+// and checks issuer, audience, expiration and nonce (if not empty).
+func (p *OIDCProvider) VerifyIDToken(raw, nonce string) (OIDCIdentity, error) {
+	cfg, err := p.discover()
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err = jwt.ParseWithClaims(raw, claims, p.keyfunc); err != nil {
+		return OIDCIdentity{}, err
+	}
+
+	if iss, _ := claims["iss"].(string); iss != cfg.Issuer {
+		return OIDCIdentity{}, errors.New("id token issuer is not valid")
+	}
+
+	if !audienceContains(claims["aud"], p.ClientID) {
+		return OIDCIdentity{}, errors.New("id token audience is not valid")
+	}
+
+	if _, ok := claims["exp"]; !ok {
+		return OIDCIdentity{}, errors.New("id token has no expiration date")
+	}
+
+	if nonce != "" {
+		if n, _ := claims["nonce"].(string); n != nonce {
+			return OIDCIdentity{}, errors.New("id token nonce is not valid")
+		}
+	}
+
+	id := OIDCIdentity{Claims: claims}
+	id.Issuer, _ = claims["iss"].(string)
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = claims["email"].(string)
+	id.EmailVerified, _ = claims["email_verified"].(bool)
+	id.Name, _ = claims["name"].(string)
+	id.PreferredUsername, _ = claims["preferred_username"].(string)
+
+	if id.Subject == "" {
+		return OIDCIdentity{}, errors.New("id token has no subject")
+	}
+
+	return id, nil
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientID
+	case []interface{}:
+		for _, v := range a {
+			if s, _ := v.(string); s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *OIDCProvider) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return http.DefaultClient
+}
+
+// discover fetches and caches provider's configuration document.
+func (p *OIDCProvider) discover() (*oidcConfig, error) {
+	p.mu.Lock()
+	cfg := p.config
+	p.mu.Unlock()
+	if cfg != nil {
+		return cfg, nil
+	}
+
+	// concurrent first requests may fetch the document more than once, which is harmless
+
+	resp, err := p.client().Get(p.Issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc discovery failed: %s", resp.Status)
+	}
+
+	cfg = &oidcConfig{}
+	if err = DecodeJSON(resp.Body, cfg); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(cfg.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer mismatch: %s", cfg.Issuer)
+	}
+	if cfg.AuthorizationEndpoint == "" || cfg.TokenEndpoint == "" || cfg.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: incomplete provider configuration")
+	}
+
+	p.mu.Lock()
+	p.config = cfg
+	p.mu.Unlock()
+
+	return cfg, nil
+}
+
+// exchange redeems authorization code at token endpoint and returns raw ID token.
+func (p *OIDCProvider) exchange(code, verifier string) (string, error) {
+	cfg, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.ClientID)
+
+	req, err := http.NewRequest(http.MethodPost, cfg.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var tok struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err = DecodeJSON(resp.Body, &tok); err != nil {
+		return "", fmt.Errorf("oidc token exchange failed: %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || tok.Error != "" {
+		return "", fmt.Errorf("oidc token exchange failed: %s %s", tok.Error, tok.ErrorDescription)
+	}
+	if tok.IDToken == "" {
+		return "", errors.New("oidc token response has no id_token")
+	}
+
+	return tok.IDToken, nil
+}
+
+// keyfunc selects ID token verification key from provider's JWKS.
+// JWKS is fetched again when token refers to an unknown key, at most once a minute.
+func (p *OIDCProvider) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		ks, err := p.jwks(attempt > 0)
+		if err != nil {
+			return nil, err
+		}
+
+		if kid == "" {
+			// tokens without 'kid' are accepted only if there is exactly one key
+			ks.mu.RLock()
+			if len(ks.keys) == 1 {
+				for id := range ks.keys {
+					kid = id
+				}
+			}
+			ks.mu.RUnlock()
+		}
+
+		if k, err := ks.VerificationKey(kid); err == nil {
+			if k.Method.Alg() != token.Method.Alg() {
+				return nil, fmt.Errorf("unexpected signing method: %s", token.Method.Alg())
+			}
+			return k.Public, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown key id: %s", kid)
+}
+
+func (p *OIDCProvider) jwks(refresh bool) (*KeySet, error) {
+	cfg, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	ks, fetched := p.keys, p.keysFetched
+	p.mu.Unlock()
+	if ks != nil && (!refresh || time.Since(fetched) < time.Minute) {
+		return ks, nil
+	}
+
+	resp, err := p.client().Get(cfg.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc jwks request failed: %s", resp.Status)
+	}
+
+	if ks, err = ParseJWKS(resp.Body); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.keys = ks
+	p.keysFetched = time.Now()
+	p.mu.Unlock()
+
+	return ks, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS reads JSON Web Key Set document from r and returns verify-only key set.
+// Supported are RSA, EC (P-256, P-384, P-521) and OKP (Ed25519) signing keys.
+func ParseJWKS(r io.Reader) (*KeySet, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	ks := NewKeySet()
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		sk, err := k.signingKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwks: key %s: %s", k.Kid, err.Error())
+		}
+		if sk != nil {
+			ks.Add(sk)
+		}
+	}
+
+	return ks, nil
+}
+
+func (k jwk) signingKey() (*SigningKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		sk := NewRSAKey(k.Kid, nil, &rsa.PublicKey{N: n, E: int(e.Int64())})
+		if k.Alg != "" {
+			if sk.Method = jwt.GetSigningMethod(k.Alg); sk.Method == nil {
+				return nil, fmt.Errorf("unsupported algorithm: %s", k.Alg)
+			}
+		}
+		return sk, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return NewECDSAKey(k.Kid, nil, &ecdsa.PublicKey{Curve: curve, X: x, Y: y})
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return NewEd25519Key(k.Kid, nil, ed25519.PublicKey(x)), nil
+	}
+
+	// unknown key types are skipped
+	return nil, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
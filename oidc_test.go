@@ -0,0 +1,323 @@
+package webutility
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// fakeIssuer is an OpenID provider serving discovery, JWKS and token endpoints.
+type fakeIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	// challenges maps issued authorization codes to PKCE challenges
+	challenges map[string]string
+	// idToken returns signed ID token for code
+	idToken func(code string) (string, error)
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeIssuer{key: key, challenges: make(map[string]string)}
+	f.idToken = func(string) (string, error) {
+		return f.sign(jwt.SigningMethodRS256, "k1", f.claims(""), f.key)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.URL,
+			"authorization_endpoint": f.URL + "/authorize",
+			"token_endpoint":         f.URL + "/token",
+			"jwks_uri":               f.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, req *http.Request) {
+		pub := f.key.PublicKey
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		code := req.PostForm.Get("code")
+
+		f.mu.Lock()
+		challenge, ok := f.challenges[code]
+		delete(f.challenges, code)
+		f.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(req.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		tok, err := f.idToken(code)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": tok})
+	})
+
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeIssuer) claims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   f.URL,
+		"aud":   "client",
+		"sub":   "ext-1",
+		"email": "alice@example.com",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": nonce,
+	}
+}
+
+func (f *fakeIssuer) sign(m jwt.SigningMethod, kid string, claims jwt.MapClaims, key interface{}) (string, error) {
+	tok := jwt.NewWithClaims(m, claims)
+	tok.Header["kid"] = kid
+	return tok.SignedString(key)
+}
+
+// login runs LoginHandler and returns state, nonce and PKCE challenge from the redirect.
+func (f *fakeIssuer) login(t *testing.T, p *OIDCProvider) (state, nonce, challenge string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	p.LoginHandler(w, httptest.NewRequest("GET", "/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want 302", w.Code)
+	}
+
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := loc.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		t.Errorf("code_challenge_method = %q, want S256", q.Get("code_challenge_method"))
+	}
+	return q.Get("state"), q.Get("nonce"), q.Get("code_challenge")
+}
+
+func (f *fakeIssuer) authorize(code, challenge string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.challenges[code] = challenge
+}
+
+func callback(p *OIDCProvider, code, state string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	q := url.Values{"code": {code}, "state": {state}}
+	p.CallbackHandler(w, httptest.NewRequest("GET", "/callback?"+q.Encode(), nil))
+	return w
+}
+
+func newTestOIDCProvider(t *testing.T, f *fakeIssuer) *OIDCProvider {
+	t.Helper()
+	useKeys(t, NewKeySet(NewHMACKey("", []byte("secret"))))
+	useTokenStores(t)
+
+	return NewOIDCProvider(f.URL, "client", "", "http://app/callback", func(id OIDCIdentity) (OIDCUser, error) {
+		return OIDCUser{Username: id.Email, RoleName: "user", RoleID: 1}, nil
+	})
+}
+
+func TestOIDCLogin(t *testing.T) {
+	f := newFakeIssuer(t)
+	p := newTestOIDCProvider(t, f)
+
+	state, nonce, challenge := f.login(t, p)
+	f.idToken = func(string) (string, error) {
+		return f.sign(jwt.SigningMethodRS256, "k1", f.claims(nonce), f.key)
+	}
+	f.authorize("code", challenge)
+
+	w := callback(p, "code", state)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+
+	var pair TokenPair
+	if err := json.NewDecoder(w.Body).Decode(&pair); err != nil {
+		t.Fatal(err)
+	}
+	if pair.Username != "alice@example.com" || pair.RefreshToken == "" {
+		t.Errorf("pair = %+v", pair)
+	}
+
+	// state can be used only once
+	f.authorize("code", challenge)
+	if w = callback(p, "code", state); w.Code != http.StatusBadRequest {
+		t.Errorf("reused state: status = %d, want 400", w.Code)
+	}
+}
+
+func TestOIDCLoginFailures(t *testing.T) {
+	f := newFakeIssuer(t)
+
+	hsKey := []byte("secret")
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// idToken signs ID token with nonce from login
+		idToken func(nonce string) (string, error)
+		// challenge replaces PKCE challenge from login, if not empty
+		challenge string
+		// state replaces state from login, if not empty
+		state string
+		want  int
+	}{
+		{
+			name:      "PKCE verifier mismatch",
+			challenge: "not-the-challenge",
+			want:      http.StatusUnauthorized,
+		},
+		{
+			name:  "state mismatch",
+			state: "unknown",
+			want:  http.StatusBadRequest,
+		},
+		{
+			name: "nonce mismatch",
+			idToken: func(string) (string, error) {
+				return f.sign(jwt.SigningMethodRS256, "k1", f.claims("other"), f.key)
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "unknown kid",
+			idToken: func(nonce string) (string, error) {
+				return f.sign(jwt.SigningMethodRS256, "k2", f.claims(nonce), other)
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong key",
+			idToken: func(nonce string) (string, error) {
+				return f.sign(jwt.SigningMethodRS256, "k1", f.claims(nonce), other)
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong alg",
+			idToken: func(nonce string) (string, error) {
+				return f.sign(jwt.SigningMethodHS256, "k1", f.claims(nonce), hsKey)
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong audience",
+			idToken: func(nonce string) (string, error) {
+				claims := f.claims(nonce)
+				claims["aud"] = "other"
+				return f.sign(jwt.SigningMethodRS256, "k1", claims, f.key)
+			},
+			want: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestOIDCProvider(t, f)
+
+			state, nonce, challenge := f.login(t, p)
+			f.idToken = func(string) (string, error) {
+				return f.sign(jwt.SigningMethodRS256, "k1", f.claims(nonce), f.key)
+			}
+			if tt.idToken != nil {
+				f.idToken = func(string) (string, error) { return tt.idToken(nonce) }
+			}
+			if tt.challenge != "" {
+				challenge = tt.challenge
+			}
+			if tt.state != "" {
+				state = tt.state
+			}
+			f.authorize("code", challenge)
+
+			if w := callback(p, "code", state); w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestOIDCMaxPendingLogins(t *testing.T) {
+	f := newFakeIssuer(t)
+	p := newTestOIDCProvider(t, f)
+	p.MaxPendingLogins = 2
+
+	f.login(t, p)
+	state, _, _ := f.login(t, p)
+
+	w := httptest.NewRecorder()
+	p.LoginHandler(w, httptest.NewRequest("GET", "/login", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", w.Code)
+	}
+
+	// finished (even failed) logins make room for new ones
+	callback(p, "code", state)
+	f.login(t, p)
+}
+
+func TestOIDCProviderLiteral(t *testing.T) {
+	f := newFakeIssuer(t)
+	useKeys(t, NewKeySet(NewHMACKey("", []byte("secret"))))
+	useTokenStores(t)
+
+	// zero LoginTimeout and no pending map, as in a provider not made by NewOIDCProvider
+	p := &OIDCProvider{
+		Issuer:      f.URL,
+		ClientID:    "client",
+		RedirectURL: "http://app/callback",
+		Scopes:      []string{"openid"},
+		Map: func(id OIDCIdentity) (OIDCUser, error) {
+			return OIDCUser{Username: id.Email, RoleName: "user", RoleID: 1}, nil
+		},
+	}
+
+	state, nonce, challenge := f.login(t, p)
+	f.idToken = func(string) (string, error) {
+		return f.sign(jwt.SigningMethodRS256, "k1", f.claims(nonce), f.key)
+	}
+	f.authorize("code", challenge)
+
+	if w := callback(p, "code", state); w.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,192 @@
+package webutility
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms.
+const (
+	PasswordArgon2id = "argon2id"
+	PasswordBcrypt   = "bcrypt"
+	// PasswordSHA256 are hashes created with CreateHash. They can only be verified.
+	PasswordSHA256 = "sha256"
+)
+
+// ErrUnknownHashFormat is returned when encoded hash is not recognized.
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// Upper bounds of argon2id parameters accepted by Verify. Stored hashes with higher cost are
+// rejected with ErrUnknownHashFormat, so a tampered row can't exhaust memory or CPU.
+var (
+	MaxArgon2Memory uint32 = 1024 * 1024 // KiB
+	MaxArgon2Time   uint32 = 16
+)
+
+// Argon2Params ...
+type Argon2Params struct {
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// PasswordPolicy controls which algorithm and cost HashPassword uses.
+// Hashes created with weaker settings are reported as needing rehash.
+type PasswordPolicy struct {
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+}
+
+// DefaultPasswordPolicy follows OWASP recommendations for argon2id.
+var DefaultPasswordPolicy = PasswordPolicy{
+	Algorithm: PasswordArgon2id,
+	Argon2: Argon2Params{
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: 2,
+		SaltLen: 16,
+		KeyLen:  32,
+	},
+	BcryptCost: 12,
+}
+
+// HashPassword hashes pass using DefaultPasswordPolicy.
+// Result is a self-describing string containing algorithm, cost and salt.
+func HashPassword(pass string) (string, error) {
+	return DefaultPasswordPolicy.Hash(pass)
+}
+
+// VerifyPassword checks pass against encoded hash using DefaultPasswordPolicy.
+func VerifyPassword(pass, encoded string) (ok, needsRehash bool, err error) {
+	return DefaultPasswordPolicy.Verify(pass, encoded)
+}
+
+// LegacyPasswordHash combines hash and salt returned by CreateHash into a string
+// that can be passed to VerifyPassword.
+func LegacyPasswordHash(hash, salt string) string {
+	return "$" + PasswordSHA256 + "$" + salt + "$" + hash
+}
+
+// Hash ...
+func (p PasswordPolicy) Hash(pass string) (string, error) {
+	switch p.Algorithm {
+	case PasswordArgon2id:
+		a := p.Argon2
+		salt := make([]byte, a.SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(pass), salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, a.Memory, a.Time, a.Threads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		), nil
+	case PasswordBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(pass), p.BcryptCost)
+		return string(hash), err
+	}
+
+	return "", fmt.Errorf("unsupported password hashing algorithm: %s", p.Algorithm)
+}
+
+// Verify compares pass with encoded hash in constant time.
+// needsRehash is true if pass matched but encoded wasn't created with p's algorithm and cost;
+// caller should then store the result of p.Hash(pass) instead.
+func (p PasswordPolicy) Verify(pass, encoded string) (ok, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		var a Argon2Params
+		var version int
+		var salt, key []byte
+		parts := strings.Split(encoded, "$")
+		if len(parts) != 6 {
+			return false, false, ErrUnknownHashFormat
+		}
+		if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+			return false, false, ErrUnknownHashFormat
+		}
+		if version != argon2.Version {
+			return false, false, fmt.Errorf("unsupported argon2 version: %d", version)
+		}
+		if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &a.Memory, &a.Time, &a.Threads); err != nil {
+			return false, false, ErrUnknownHashFormat
+		}
+		if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+			return false, false, ErrUnknownHashFormat
+		}
+		if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+			return false, false, ErrUnknownHashFormat
+		}
+		// argon2.IDKey panics on these
+		if len(key) == 0 || a.Time < 1 || a.Threads < 1 || a.Time > MaxArgon2Time || a.Memory > MaxArgon2Memory {
+			return false, false, ErrUnknownHashFormat
+		}
+		a.SaltLen = uint32(len(salt))
+		a.KeyLen = uint32(len(key))
+
+		other := argon2.IDKey([]byte(pass), salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, false, nil
+		}
+
+		needsRehash = p.Algorithm != PasswordArgon2id ||
+			a.Memory < p.Argon2.Memory ||
+			a.Time < p.Argon2.Time ||
+			a.Threads < p.Argon2.Threads ||
+			a.KeyLen < p.Argon2.KeyLen ||
+			a.SaltLen < p.Argon2.SaltLen
+		return true, needsRehash, nil
+
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		err = bcrypt.CompareHashAndPassword([]byte(encoded), []byte(pass))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, false, nil
+		} else if err != nil {
+			return false, false, err
+		}
+
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return false, false, err
+		}
+		return true, p.Algorithm != PasswordBcrypt || cost < p.BcryptCost, nil
+
+	case strings.HasPrefix(encoded, "$"+PasswordSHA256+"$"):
+		parts := strings.Split(encoded, "$")
+		if len(parts) != 4 {
+			return false, false, ErrUnknownHashFormat
+		}
+		if ok, err = ValidateHash(pass, parts[2], parts[3]); err != nil || !ok {
+			return false, false, err
+		}
+		// legacy hashes are always upgraded
+		return true, true, nil
+	}
+
+	return false, false, ErrUnknownHashFormat
+}
+
+// constantTimeEqualHex compares two hex encoded strings in constant time.
+func constantTimeEqualHex(a, b string) bool {
+	rawa, err := hex.DecodeString(a)
+	if err != nil {
+		return false
+	}
+	rawb, err := hex.DecodeString(b)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(rawa, rawb) == 1
+}
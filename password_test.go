@@ -0,0 +1,115 @@
+package webutility
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPasswordPolicy is cheap enough to keep tests fast.
+var testPasswordPolicy = PasswordPolicy{
+	Algorithm:  PasswordArgon2id,
+	Argon2:     Argon2Params{Time: 1, Memory: 64, Threads: 1, SaltLen: 16, KeyLen: 32},
+	BcryptCost: bcrypt.MinCost,
+}
+
+func TestPasswordVerify(t *testing.T) {
+	argon, err := testPasswordPolicy.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bcryptPolicy := testPasswordPolicy
+	bcryptPolicy.Algorithm = PasswordBcrypt
+	bc, err := bcryptPolicy.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, salt, err := CreateHash("secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := LegacyPasswordHash(hash, salt)
+
+	// flip the last character of the argon2 key
+	parts := strings.Split(argon, "$")
+	key := []byte(parts[5])
+	if key[len(key)-2] == 'A' {
+		key[len(key)-2] = 'B'
+	} else {
+		key[len(key)-2] = 'A'
+	}
+	parts[5] = string(key)
+	tamperedKey := strings.Join(parts, "$")
+
+	parts = strings.Split(argon, "$")
+	parts[3] = "m=64,t=2,p=1"
+	tamperedCost := strings.Join(parts, "$")
+
+	tests := []struct {
+		name        string
+		pass        string
+		encoded     string
+		ok          bool
+		needsRehash bool
+		err         error
+	}{
+		{"argon2id", "secret", argon, true, false, nil},
+		{"argon2id wrong password", "guess", argon, false, false, nil},
+		{"bcrypt", "secret", bc, true, true, nil},
+		{"bcrypt wrong password", "guess", bc, false, false, nil},
+		{"legacy", "secret", legacy, true, true, nil},
+		{"legacy wrong password", "guess", legacy, false, false, nil},
+
+		{"tampered key", "secret", tamperedKey, false, false, nil},
+		{"tampered cost", "secret", tamperedCost, false, false, nil},
+
+		{"empty", "secret", "", false, false, ErrUnknownHashFormat},
+		{"unknown algorithm", "secret", "$scrypt$c2FsdA$a2V5", false, false, ErrUnknownHashFormat},
+		{"missing part", "secret", "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ", false, false, ErrUnknownHashFormat},
+		{"empty key", "secret", "$argon2id$v=19$m=8,t=1,p=1$c2FsdHNhbHQ$", false, false, ErrUnknownHashFormat},
+		{"zero time", "secret", "$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$a2V5a2V5", false, false, ErrUnknownHashFormat},
+		{"zero threads", "secret", "$argon2id$v=19$m=64,t=1,p=0$c2FsdHNhbHQ$a2V5a2V5", false, false, ErrUnknownHashFormat},
+		{"threads overflow", "secret", "$argon2id$v=19$m=64,t=1,p=300$c2FsdHNhbHQ$a2V5a2V5", false, false, ErrUnknownHashFormat},
+		{"huge memory", "secret", "$argon2id$v=19$m=4294967295,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5", false, false, ErrUnknownHashFormat},
+		{"huge time", "secret", "$argon2id$v=19$m=64,t=4294967295,p=1$c2FsdHNhbHQ$a2V5a2V5", false, false, ErrUnknownHashFormat},
+		{"bad params", "secret", "$argon2id$v=19$memory$c2FsdHNhbHQ$a2V5a2V5", false, false, ErrUnknownHashFormat},
+		{"bad salt", "secret", "$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5a2V5", false, false, ErrUnknownHashFormat},
+		{"bad key", "secret", "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$!!!", false, false, ErrUnknownHashFormat},
+		{"legacy missing part", "secret", "$sha256$" + salt, false, false, ErrUnknownHashFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash, err := testPasswordPolicy.Verify(tt.pass, tt.encoded)
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if ok != tt.ok || needsRehash != tt.needsRehash {
+				t.Errorf("ok, needsRehash = %v, %v, want %v, %v", ok, needsRehash, tt.ok, tt.needsRehash)
+			}
+		})
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	weak := testPasswordPolicy
+	weak.Argon2.Time = 1
+
+	strong := testPasswordPolicy
+	strong.Argon2.Time = 2
+
+	encoded, err := weak.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, needsRehash, err := strong.Verify("secret", encoded); err != nil || !ok || !needsRehash {
+		t.Errorf("ok, needsRehash, err = %v, %v, %v, want true, true, nil", ok, needsRehash, err)
+	}
+	if ok, needsRehash, err := weak.Verify("secret", encoded); err != nil || !ok || needsRehash {
+		t.Errorf("ok, needsRehash, err = %v, %v, %v, want true, false, nil", ok, needsRehash, err)
+	}
+}
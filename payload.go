@@ -22,6 +22,9 @@ var (
 
 	inited     bool
 	metaDriver string
+
+	hotloadMu   sync.Mutex
+	hotloadStop []chan struct{}
 )
 
 // LangMap ...
@@ -116,8 +119,24 @@ func InitPayloadsMetadata(drv string, db *sql.DB, project string) error {
 // EnableHotloading ...
 func EnableHotloading(interval int) {
 	if interval > 0 {
-		go hotload(interval)
+		hotloadMu.Lock()
+		defer hotloadMu.Unlock()
+
+		stop := make(chan struct{})
+		hotloadStop = append(hotloadStop, stop)
+		go hotload(interval, stop)
+	}
+}
+
+// DisableHotloading stops goroutines started with EnableHotloading.
+func DisableHotloading() {
+	hotloadMu.Lock()
+	defer hotloadMu.Unlock()
+
+	for _, stop := range hotloadStop {
+		close(stop)
 	}
+	hotloadStop = nil
 }
 
 // GetMetadataForAllEntities ...
@@ -291,11 +310,15 @@ func LoadMetadataFromFile(path string) error {
 	return nil
 }
 
-func hotload(n int) {
+func hotload(n int, stop <-chan struct{}) {
 	entityScan := make(map[string]int64)
 	firstCheck := true
 	for {
-		time.Sleep(time.Duration(n) * time.Second)
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Duration(n) * time.Second):
+		}
 		rows, err := metadataDB.Query(`select
 			ora_rowscn,
 			entity_type
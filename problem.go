@@ -0,0 +1,141 @@
+package webutility
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// UseProblemJSON makes Error and helpers built on it (BadRequest, NotFound, ...)
+// respond with RFC 7807 application/problem+json instead of the default error object.
+var UseProblemJSON = false
+
+// ProblemTypeBase is prepended to problem's Code to build its type URI when Type is not set.
+// If empty, type is left out which means "about:blank".
+var ProblemTypeBase = ""
+
+// Problem is RFC 7807 problem details object. It implements error so it can be returned
+// from handlers and rendered with WriteError.
+type Problem struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Code is application specific, machine-readable error code.
+	Code string `json:"code,omitempty"`
+
+	// Extensions are additional members serialized alongside the standard ones.
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// FieldError describes a problem with a single request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+
+	// param is validation rule parameter, used by ValidationErrors.Localize
+	param string
+}
+
+// NewProblem ...
+func NewProblem(status int, code, detail string) *Problem {
+	return &Problem{
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Error ...
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	return p.Title
+}
+
+// With sets extension member key to v.
+func (p *Problem) With(key string, v interface{}) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]interface{})
+	}
+	p.Extensions[key] = v
+	return p
+}
+
+// WithFieldErrors sets "errors" extension member to errs.
+func (p *Problem) WithFieldErrors(errs []FieldError) *Problem {
+	return p.With("errors", errs)
+}
+
+// MarshalJSON flattens extension members into the problem object.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	type problem Problem
+	return marshalWithExtensions((*problem)(p), p.Extensions)
+}
+
+// marshalWithExtensions marshals v and adds members of ext to the resulting object.
+// Members of v take precedence.
+func marshalWithExtensions(v interface{}, ext map[string]interface{}) ([]byte, error) {
+	std, err := json.Marshal(v)
+	if err != nil || len(ext) == 0 {
+		return std, err
+	}
+
+	m := make(map[string]interface{}, len(ext)+6)
+	for k, v := range ext {
+		m[k] = v
+	}
+	var stdm map[string]interface{}
+	if err = json.Unmarshal(std, &stdm); err != nil {
+		return nil, err
+	}
+	for k, v := range stdm {
+		m[k] = v
+	}
+
+	return json.Marshal(m)
+}
+
+// WriteProblem writes p to w as application/problem+json.
+// Instance defaults to requested URI.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	out := *p
+	if out.Status == 0 {
+		out.Status = http.StatusInternalServerError
+	}
+	if out.Title == "" {
+		out.Title = http.StatusText(out.Status)
+	}
+	if out.Type == "" && out.Code != "" && ProblemTypeBase != "" {
+		out.Type = ProblemTypeBase + out.Code
+	}
+	if out.Instance == "" {
+		out.Instance = r.RequestURI
+	}
+
+	SetContentType(w, "application/problem+json")
+	w.WriteHeader(out.Status)
+	json.NewEncoder(w).Encode(&out)
+}
+
+// WriteError writes err to w. *Problem errors (including wrapped ones) are rendered as problems,
+// any other error as 500 Internal Server Error. Without UseProblemJSON problem's extension members
+// are added to the error object.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	var p *Problem
+	if errors.As(err, &p) {
+		if UseProblemJSON {
+			WriteProblem(w, r, p)
+		} else {
+			SetContentType(w, "application/json")
+			writeWebError(w, r, p.Status, p.Code, p.Error(), p.Extensions)
+		}
+		return
+	}
+
+	InternalServerError(w, r, err.Error())
+}
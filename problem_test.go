@@ -0,0 +1,115 @@
+package webutility
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var m map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+		t.Fatalf("body %q: %v", w.Body.String(), err)
+	}
+	return m
+}
+
+func TestWriteProblem(t *testing.T) {
+	base := ProblemTypeBase
+	ProblemTypeBase = "https://example.com/errors/"
+	defer func() { ProblemTypeBase = base }()
+
+	w := httptest.NewRecorder()
+	WriteProblem(w, httptest.NewRequest("GET", "/orders/7", nil), &Problem{Status: http.StatusNotFound, Code: "order_not_found"})
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	want := map[string]interface{}{
+		"type":     "https://example.com/errors/order_not_found",
+		"title":    "Not Found",
+		"status":   float64(404),
+		"instance": "/orders/7",
+		"code":     "order_not_found",
+	}
+	got := decodeBody(t, w)
+	if len(got) != len(want) {
+		t.Errorf("members = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+}
+
+func TestProblemMarshalJSON(t *testing.T) {
+	p := NewProblem(http.StatusConflict, "stale", "order was modified").
+		With("version", 3).
+		With("status", "extension can't override standard members")
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	json.Unmarshal(b, &m)
+	if m["version"] != float64(3) || m["status"] != float64(409) || m["detail"] != "order was modified" {
+		t.Errorf("got %s", b)
+	}
+
+	if b, _ = json.Marshal(NewProblem(http.StatusConflict, "", "")); string(b) != `{"title":"Conflict","status":409}` {
+		t.Errorf("without extensions: %s", b)
+	}
+}
+
+func TestUseProblemJSON(t *testing.T) {
+	defer func(v bool) { UseProblemJSON = v }(UseProblemJSON)
+
+	p := NewProblem(http.StatusBadRequest, "invalid", "invalid order").
+		WithFieldErrors([]FieldError{{Field: "qty", Code: "min", Message: "too small"}}).
+		With("limit", 10)
+	err := fmt.Errorf("create order: %w", p)
+
+	UseProblemJSON = true
+	w := httptest.NewRecorder()
+	WriteError(w, httptest.NewRequest("POST", "/orders", nil), err)
+	m := decodeBody(t, w)
+	if w.Header().Get("Content-Type") != "application/problem+json" || m["code"] != "invalid" || m["limit"] != float64(10) {
+		t.Errorf("problem: %s %s", w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	UseProblemJSON = false
+	w = httptest.NewRecorder()
+	WriteError(w, httptest.NewRequest("POST", "/orders", nil), err)
+	m = decodeBody(t, w)
+	if w.Code != http.StatusBadRequest || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("status = %d, Content-Type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if m["error"] != "invalid order" || m["request"] != "POST /orders" || m["code"] != "invalid" {
+		t.Errorf("error object: %s", w.Body.String())
+	}
+	// extensions are kept in the error object too
+	if fields, _ := m["errors"].([]interface{}); len(fields) != 1 || m["limit"] != float64(10) {
+		t.Errorf("extensions lost: %s", w.Body.String())
+	}
+
+	// Error helpers follow the toggle as well
+	w = httptest.NewRecorder()
+	NotFound(w, httptest.NewRequest("GET", "/x", nil), "no such thing")
+	if m = decodeBody(t, w); m["error"] != "no such thing" {
+		t.Errorf("NotFound: %s", w.Body.String())
+	}
+	UseProblemJSON = true
+	w = httptest.NewRecorder()
+	NotFound(w, httptest.NewRequest("GET", "/x", nil), "no such thing")
+	if m = decodeBody(t, w); m["detail"] != "no such thing" || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("NotFound with UseProblemJSON: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,293 @@
+package webutility
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+// LimitState is per-key state of rate limiters and lockouts.
+type LimitState struct {
+	// token bucket
+	Tokens float64
+	Last   time.Time
+
+	// sliding window and lockout counters
+	Window    int64
+	Count     int64
+	PrevCount int64
+	Until     time.Time
+}
+
+// RateLimitStore keeps LimitState for keys. Implement it to share limits between
+// multiple instances of a service, e.g. with a Redis script.
+type RateLimitStore interface {
+	// Update atomically loads state of key (zero value if missing), passes it to fn and stores it.
+	// State may be discarded after ttl of inactivity.
+	Update(key string, ttl time.Duration, fn func(s *LimitState)) error
+	// Delete ...
+	Delete(key string) error
+}
+
+// LimitResult ...
+type LimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // time until the limit is fully restored
+	RetryAfter time.Duration // time until next request will be allowed, if denied
+}
+
+// Limiter decides whether a request identified by key is allowed.
+type Limiter interface {
+	Allow(key string) (LimitResult, error)
+}
+
+const memoryStoreShards = 64
+
+// MemoryRateLimitStore is in-memory RateLimitStore. Keys are spread over shards
+// with separate locks to reduce contention.
+type MemoryRateLimitStore struct {
+	shards [memoryStoreShards]limitShard
+}
+
+type limitShard struct {
+	mu      sync.Mutex
+	entries map[string]*limitEntry
+	ops     int
+}
+
+type limitEntry struct {
+	state   LimitState
+	expires time.Time
+}
+
+// NewMemoryRateLimitStore ...
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]*limitEntry)
+	}
+	return s
+}
+
+func (s *MemoryRateLimitStore) shard(key string) *limitShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &s.shards[h.Sum32()%memoryStoreShards]
+}
+
+// Update ...
+func (s *MemoryRateLimitStore) Update(key string, ttl time.Duration, fn func(s *LimitState)) error {
+	sh := s.shard(key)
+	now := time.Now()
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	// sweep expired entries every now and then
+	sh.ops++
+	if sh.ops >= 1000 {
+		sh.ops = 0
+		for k, e := range sh.entries {
+			if now.After(e.expires) {
+				delete(sh.entries, k)
+			}
+		}
+	}
+
+	e, ok := sh.entries[key]
+	if !ok || now.After(e.expires) {
+		e = &limitEntry{}
+		sh.entries[key] = e
+	}
+	fn(&e.state)
+	e.expires = now.Add(ttl)
+
+	return nil
+}
+
+// Delete ...
+func (s *MemoryRateLimitStore) Delete(key string) error {
+	sh := s.shard(key)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	delete(sh.entries, key)
+	return nil
+}
+
+// TokenBucket allows bursts of up to Burst requests, refilled at Limit requests per Period.
+type TokenBucket struct {
+	Limit  int
+	Period time.Duration
+	Burst  int
+	Store  RateLimitStore
+}
+
+// NewTokenBucket returns in-memory token bucket limiter.
+func NewTokenBucket(limit int, period time.Duration, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = limit
+	}
+	return &TokenBucket{
+		Limit:  limit,
+		Period: period,
+		Burst:  burst,
+		Store:  NewMemoryRateLimitStore(),
+	}
+}
+
+// Allow ...
+func (b *TokenBucket) Allow(key string) (res LimitResult, err error) {
+	rate := float64(b.Limit) / b.Period.Seconds() // tokens per second
+	burst := float64(b.Burst)
+	now := time.Now()
+
+	ttl := time.Duration(burst/rate*float64(time.Second)) + time.Second
+	err = b.Store.Update(key, ttl, func(s *LimitState) {
+		if s.Last.IsZero() {
+			s.Tokens = burst
+		} else {
+			s.Tokens = math.Min(burst, s.Tokens+now.Sub(s.Last).Seconds()*rate)
+		}
+		s.Last = now
+
+		if s.Tokens >= 1 {
+			s.Tokens--
+			res.Allowed = true
+		} else {
+			res.RetryAfter = secondsToDuration((1 - s.Tokens) / rate)
+		}
+		res.Remaining = int(s.Tokens)
+		res.Reset = secondsToDuration((burst - s.Tokens) / rate)
+	})
+	res.Limit = b.Burst
+
+	return res, err
+}
+
+// SlidingWindow allows Limit requests in any Window long period. It uses weighted
+// counters of the current and previous fixed windows to approximate the sliding window.
+type SlidingWindow struct {
+	Limit  int
+	Window time.Duration
+	Store  RateLimitStore
+}
+
+// NewSlidingWindow returns in-memory sliding window limiter.
+func NewSlidingWindow(limit int, window time.Duration) *SlidingWindow {
+	return &SlidingWindow{
+		Limit:  limit,
+		Window: window,
+		Store:  NewMemoryRateLimitStore(),
+	}
+}
+
+// Allow ...
+func (sw *SlidingWindow) Allow(key string) (res LimitResult, err error) {
+	now := time.Now()
+	w := now.UnixNano() / int64(sw.Window)
+	windowStart := time.Unix(0, w*int64(sw.Window))
+	elapsed := float64(now.Sub(windowStart)) / float64(sw.Window)
+	limit := float64(sw.Limit)
+
+	err = sw.Store.Update(key, sw.Window*2, func(s *LimitState) {
+		if s.Window != w {
+			if s.Window == w-1 {
+				s.PrevCount = s.Count
+			} else {
+				s.PrevCount = 0
+			}
+			s.Count = 0
+			s.Window = w
+		}
+
+		prev := float64(s.PrevCount) * (1 - elapsed)
+		estimate := prev + float64(s.Count)
+		if estimate+1 <= limit {
+			s.Count++
+			estimate++
+			res.Allowed = true
+		} else if float64(s.Count)+1 > limit || s.PrevCount == 0 {
+			// can't go through before the current window ends
+			res.RetryAfter = windowStart.Add(sw.Window).Sub(now)
+		} else {
+			// wait until previous window's weight drops enough
+			need := 1 - (limit-float64(s.Count)-1)/float64(s.PrevCount)
+			res.RetryAfter = time.Duration((need - elapsed) * float64(sw.Window))
+		}
+
+		res.Remaining = int(math.Max(0, limit-estimate))
+	})
+	res.Limit = sw.Limit
+	res.Reset = windowStart.Add(sw.Window).Sub(now)
+
+	return res, err
+}
+
+// Lockout locks out a key (e.g. username) after MaxAttempts consecutive failures.
+// Each subsequent failure doubles the lockout period, starting at BaseDelay and capped at MaxDelay.
+type Lockout struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Window is the time after which failure count is forgotten.
+	Window time.Duration
+	Store  RateLimitStore
+}
+
+// NewLockout returns in-memory lockout.
+func NewLockout(maxAttempts int, baseDelay, maxDelay time.Duration) *Lockout {
+	return &Lockout{
+		MaxAttempts: maxAttempts,
+		BaseDelay:   baseDelay,
+		MaxDelay:    maxDelay,
+		Window:      time.Hour * 24,
+		Store:       NewMemoryRateLimitStore(),
+	}
+}
+
+// Check returns time remaining until key is unlocked, zero if it's not locked.
+// Call it before validating credentials.
+func (l *Lockout) Check(key string) (retryAfter time.Duration, err error) {
+	now := time.Now()
+	err = l.Store.Update(key, l.Window, func(s *LimitState) {
+		if now.Before(s.Until) {
+			retryAfter = s.Until.Sub(now)
+		}
+	})
+	return retryAfter, err
+}
+
+// Fail records failed attempt and returns lockout period it caused, if any.
+func (l *Lockout) Fail(key string) (lockedFor time.Duration, err error) {
+	now := time.Now()
+	err = l.Store.Update(key, l.Window, func(s *LimitState) {
+		s.Count++
+		over := s.Count - int64(l.MaxAttempts)
+		if over < 0 {
+			return
+		}
+
+		lockedFor = l.MaxDelay
+		if over < 32 {
+			if d := l.BaseDelay << uint(over); d > 0 && d < l.MaxDelay {
+				lockedFor = d
+			}
+		}
+		s.Until = now.Add(lockedFor)
+	})
+	return lockedFor, err
+}
+
+// Reset clears failures of key. Call it after successful login.
+func (l *Lockout) Reset(key string) error {
+	return l.Store.Delete(key)
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
@@ -0,0 +1,111 @@
+package webutility
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	// 100 tokens per second, bursts of 3
+	b := NewTokenBucket(10, 100*time.Millisecond, 3)
+
+	for i := 0; i < 3; i++ {
+		if res, _ := b.Allow("k"); !res.Allowed || res.Remaining != 2-i {
+			t.Fatalf("request %d: %+v", i, res)
+		}
+	}
+	res, _ := b.Allow("k")
+	if res.Allowed || res.RetryAfter <= 0 || res.RetryAfter > 10*time.Millisecond {
+		t.Errorf("over burst: %+v", res)
+	}
+	if res, _ := b.Allow("other"); !res.Allowed {
+		t.Error("keys share a bucket")
+	}
+
+	time.Sleep(res.RetryAfter + 5*time.Millisecond)
+	if res, _ = b.Allow("k"); !res.Allowed {
+		t.Errorf("token not refilled: %+v", res)
+	}
+
+	// refill is capped at burst
+	time.Sleep(50 * time.Millisecond)
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if res, _ := b.Allow("k"); res.Allowed {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("allowed %d after full refill, want 3", allowed)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	sw := NewSlidingWindow(3, time.Hour)
+	for i := 0; i < 3; i++ {
+		if res, _ := sw.Allow("k"); !res.Allowed {
+			t.Fatalf("request %d denied", i)
+		}
+	}
+	res, _ := sw.Allow("k")
+	if res.Allowed || res.RetryAfter <= 0 || res.RetryAfter > time.Hour || res.Remaining != 0 {
+		t.Errorf("over limit: %+v", res)
+	}
+
+	// full previous window counts with the part of it still inside the sliding window
+	sw = NewSlidingWindow(100, time.Hour)
+	now := time.Now()
+	w := now.UnixNano() / int64(time.Hour)
+	elapsed := float64(now.UnixNano()%int64(time.Hour)) / float64(time.Hour)
+	sw.Store.Update("k", time.Hour, func(s *LimitState) {
+		s.Window, s.Count = w-1, 100
+	})
+
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		if res, _ := sw.Allow("k"); res.Allowed {
+			allowed++
+		}
+	}
+	if want := int(100 * elapsed); allowed < want-1 || allowed > want+1 {
+		t.Errorf("allowed %d with full previous window at %.2f of current, want %d", allowed, elapsed, want)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	l := NewLockout(2, 20*time.Millisecond, 50*time.Millisecond)
+
+	if d, _ := l.Fail("alice"); d != 0 {
+		t.Errorf("first failure locked for %v", d)
+	}
+	if d, _ := l.Fail("alice"); d != 20*time.Millisecond {
+		t.Errorf("second failure locked for %v, want 20ms", d)
+	}
+	if d, _ := l.Check("alice"); d <= 0 || d > 20*time.Millisecond {
+		t.Errorf("Check = %v, want locked", d)
+	}
+	if d, _ := l.Check("bob"); d != 0 {
+		t.Errorf("bob locked for %v", d)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if d, _ := l.Check("alice"); d != 0 {
+		t.Errorf("lockout didn't expire, %v left", d)
+	}
+
+	// further failures double the period up to MaxDelay
+	if d, _ := l.Fail("alice"); d != 40*time.Millisecond {
+		t.Errorf("third failure locked for %v, want 40ms", d)
+	}
+	if d, _ := l.Fail("alice"); d != 50*time.Millisecond {
+		t.Errorf("fourth failure locked for %v, want 50ms", d)
+	}
+
+	l.Reset("alice")
+	if d, _ := l.Check("alice"); d != 0 {
+		t.Errorf("locked for %v after Reset", d)
+	}
+	if d, _ := l.Fail("alice"); d != 0 {
+		t.Errorf("failure count not reset, locked for %v", d)
+	}
+}
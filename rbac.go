@@ -0,0 +1,292 @@
+package webutility
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrPermissionDenied is returned when role doesn't have the requested permission.
+var ErrPermissionDenied = errors.New("permission denied")
+
+var _rbac = NewRBAC()
+
+// Role is a named set of permissions. Role inherits all permissions of its parent roles.
+// Permissions are in "resource:action" format, "resource:*" and "*" are wildcards.
+type Role struct {
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Inherits    []string `json:"inherits"`
+	Permissions []string `json:"permissions"`
+}
+
+// RBAC ...
+type RBAC struct {
+	mu     sync.RWMutex
+	roles  map[string]*Role
+	byID   map[int64]string
+	cached map[string]map[string]bool
+}
+
+// NewRBAC ...
+func NewRBAC() *RBAC {
+	return &RBAC{
+		roles:  make(map[string]*Role),
+		byID:   make(map[int64]string),
+		cached: make(map[string]map[string]bool),
+	}
+}
+
+// InitRBAC sets r as the RBAC used by PermissionCheck.
+func InitRBAC(r *RBAC) {
+	_rbac = r
+}
+
+// GetRBAC returns RBAC used by PermissionCheck.
+func GetRBAC() *RBAC {
+	return _rbac
+}
+
+// AddRole adds role or replaces existing one with the same name.
+func (r *RBAC) AddRole(role Role) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rl := role
+	r.roles[rl.Name] = &rl
+	if rl.ID != 0 {
+		r.byID[rl.ID] = rl.Name
+	}
+	r.cached = resolveAll(r.roles)
+}
+
+// LoadJSON loads roles from file at path in format:
+//
+//	[
+//	    {"id": 1, "name": "user", "permissions": ["documents:read"]},
+//	    {"id": 2, "name": "admin", "inherits": ["user"], "permissions": ["documents:*"]}
+//	]
+//
+// Previously loaded roles are discarded.
+func (r *RBAC) LoadJSON(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var roles []Role
+	if err = json.Unmarshal(data, &roles); err != nil {
+		return err
+	}
+
+	return r.replace(roles)
+}
+
+// LoadSQL loads roles from database tables:
+//
+// roles(role_id, role_name)
+// role_inheritance(role_id, parent_role_id)
+// role_permissions(role_id, permission)
+//
+// Previously loaded roles are discarded.
+func (r *RBAC) LoadSQL(db *sql.DB) error {
+	rolesByID := make(map[int64]*Role)
+	var ids []int64
+
+	rows, err := db.Query("select role_id, role_name from roles")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		rl := &Role{}
+		if err = rows.Scan(&rl.ID, &rl.Name); err != nil {
+			rows.Close()
+			return err
+		}
+		rolesByID[rl.ID] = rl
+		ids = append(ids, rl.ID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.Query("select role_id, parent_role_id from role_inheritance")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id, parent int64
+		if err = rows.Scan(&id, &parent); err != nil {
+			rows.Close()
+			return err
+		}
+		rl, ok := rolesByID[id]
+		p, pok := rolesByID[parent]
+		if ok && pok {
+			rl.Inherits = append(rl.Inherits, p.Name)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.Query("select role_id, permission from role_permissions")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id int64
+		var perm string
+		if err = rows.Scan(&id, &perm); err != nil {
+			rows.Close()
+			return err
+		}
+		if rl, ok := rolesByID[id]; ok {
+			rl.Permissions = append(rl.Permissions, perm)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	roles := make([]Role, 0, len(ids))
+	for _, id := range ids {
+		roles = append(roles, *rolesByID[id])
+	}
+
+	return r.replace(roles)
+}
+
+// replace swaps current roles for roles. Current roles are kept if roles are not valid.
+func (r *RBAC) replace(roles []Role) error {
+	byName := make(map[string]*Role, len(roles))
+	byID := make(map[int64]string, len(roles))
+	for i := range roles {
+		rl := roles[i]
+		if rl.Name == "" {
+			return errors.New("webutility: role without name")
+		}
+		byName[rl.Name] = &rl
+		if rl.ID != 0 {
+			byID[rl.ID] = rl.Name
+		}
+	}
+	cached := resolveAll(byName)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.roles = byName
+	r.byID = byID
+	r.cached = cached
+
+	return nil
+}
+
+// RoleName returns name of role with id.
+func (r *RBAC) RoleName(id int64) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	name, ok := r.byID[id]
+	return name, ok
+}
+
+// Permissions returns all permissions of role, including inherited ones.
+func (r *RBAC) Permissions(role string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	perms := make([]string, 0)
+	for p := range r.cached[role] {
+		perms = append(perms, p)
+	}
+	sort.Strings(perms)
+
+	return perms
+}
+
+// HasPermission reports whether role has perm, directly, through inheritance or a wildcard.
+func (r *RBAC) HasPermission(role, perm string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	perms := r.cached[role]
+	if perms["*"] || perms[perm] {
+		return true
+	}
+
+	if i := strings.Index(perm, ":"); i != -1 {
+		return perms[perm[:i]+":*"]
+	}
+
+	return false
+}
+
+// Can reports whether claims' role has perm. Role is looked up by RoleID first, then by RoleName.
+func (r *RBAC) Can(claims *TokenClaims, perm string) bool {
+	role := claims.RoleName
+	if name, ok := r.RoleName(claims.RoleID); ok {
+		role = name
+	}
+	return r.HasPermission(role, perm)
+}
+
+// resolveAll returns flattened permission sets of all roles.
+func resolveAll(roles map[string]*Role) map[string]map[string]bool {
+	cached := make(map[string]map[string]bool, len(roles))
+	for name := range roles {
+		cached[name] = resolve(roles, name)
+	}
+	return cached
+}
+
+// resolve returns flattened permission set of role.
+func resolve(roles map[string]*Role, role string) map[string]bool {
+	perms := make(map[string]bool)
+	visited := make(map[string]bool)
+
+	var walk func(name string)
+	walk = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+
+		rl, ok := roles[name]
+		if !ok {
+			return
+		}
+		for _, p := range rl.Permissions {
+			perms[p] = true
+		}
+		for _, parent := range rl.Inherits {
+			walk(parent)
+		}
+	}
+	walk(role)
+
+	return perms
+}
+
+// PermissionCheck validates token from req and checks if its role has perm.
+func PermissionCheck(req *http.Request, perm string) (*TokenClaims, error) {
+	claims, err := AuthCheck(req, "*")
+	if err != nil {
+		return claims, err
+	}
+
+	if !_rbac.Can(claims, perm) {
+		return claims, ErrPermissionDenied
+	}
+
+	return claims, nil
+}
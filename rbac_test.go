@@ -0,0 +1,119 @@
+package webutility
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func testRBAC() *RBAC {
+	r := NewRBAC()
+	r.AddRole(Role{ID: 1, Name: "user", Permissions: []string{"documents:read"}})
+	r.AddRole(Role{ID: 2, Name: "editor", Inherits: []string{"user"}, Permissions: []string{"documents:*"}})
+	r.AddRole(Role{ID: 3, Name: "admin", Inherits: []string{"editor"}, Permissions: []string{"*"}})
+	return r
+}
+
+func TestRBACHasPermission(t *testing.T) {
+	r := testRBAC()
+
+	tests := []struct {
+		role, perm string
+		want       bool
+	}{
+		{"user", "documents:read", true},
+		{"user", "documents:write", false},
+		{"editor", "documents:write", true},
+		{"editor", "documents:read", true},
+		{"editor", "users:read", false},
+		{"admin", "users:delete", true},
+		{"guest", "documents:read", false},
+	}
+	for _, tt := range tests {
+		if got := r.HasPermission(tt.role, tt.perm); got != tt.want {
+			t.Errorf("HasPermission(%s, %s) = %v, want %v", tt.role, tt.perm, got, tt.want)
+		}
+	}
+
+	want := []string{"documents:*", "documents:read"}
+	if got := r.Permissions("editor"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Permissions(editor) = %v, want %v", got, want)
+	}
+}
+
+func TestRBACInheritanceCycle(t *testing.T) {
+	r := NewRBAC()
+	r.AddRole(Role{Name: "a", Inherits: []string{"b"}, Permissions: []string{"a:x"}})
+	r.AddRole(Role{Name: "b", Inherits: []string{"a"}, Permissions: []string{"b:x"}})
+
+	if !r.HasPermission("a", "b:x") || !r.HasPermission("b", "a:x") {
+		t.Error("permissions not inherited through cycle")
+	}
+}
+
+func TestRBACCan(t *testing.T) {
+	r := testRBAC()
+
+	// RoleID takes precedence over RoleName
+	if !r.Can(&TokenClaims{RoleID: 3, RoleName: "user"}, "users:delete") {
+		t.Error("role not looked up by ID")
+	}
+	if !r.Can(&TokenClaims{RoleName: "editor"}, "documents:write") {
+		t.Error("role not looked up by name")
+	}
+}
+
+func TestRBACLoadJSONKeepsRolesOnError(t *testing.T) {
+	r := testRBAC()
+
+	path := filepath.Join(t.TempDir(), "roles.json")
+	if err := os.WriteFile(path, []byte(`[{"id": 4, "name": "guest"}, {"id": 5}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.LoadJSON(path); err == nil {
+		t.Fatal("role without name accepted")
+	}
+	if !r.HasPermission("admin", "users:delete") {
+		t.Error("admin lost permissions after failed load")
+	}
+	if _, ok := r.RoleName(4); ok {
+		t.Error("role from failed load was added")
+	}
+
+	if err := os.WriteFile(path, []byte(`[{"id": 4, "name": "guest", "permissions": ["documents:read"]}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.LoadJSON(path); err != nil {
+		t.Fatal(err)
+	}
+	if r.HasPermission("admin", "users:delete") {
+		t.Error("previously loaded roles were not discarded")
+	}
+	if !r.HasPermission("guest", "documents:read") {
+		t.Error("loaded role has no permissions")
+	}
+}
+
+func TestRBACConcurrentAccess(t *testing.T) {
+	r := testRBAC()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if i == 0 {
+					r.AddRole(Role{Name: "user", Permissions: []string{"documents:read"}})
+					continue
+				}
+				r.HasPermission("admin", "documents:read")
+				r.Permissions("editor")
+			}
+		}(i)
+	}
+	wg.Wait()
+}
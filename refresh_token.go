@@ -0,0 +1,430 @@
+package webutility
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default token lifetimes used by IssueTokenPair.
+var (
+	AccessTokenLifetime  = time.Minute * 15
+	RefreshTokenLifetime = time.Hour * 24 * 30
+)
+
+// ErrInvalidRefreshToken is returned when refresh token is unknown, already used or expired.
+var ErrInvalidRefreshToken = errors.New("refresh token is not valid")
+
+// ErrTokenRevoked is returned for tokens whose ID is on the revocation list.
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// ErrTokenHasNoID is returned by RevokeAuthToken for principals without token ID (jti),
+// such as the ones from API key and Basic authenticators. They can't be revoked.
+var ErrTokenHasNoID = errors.New("token has no ID")
+
+var (
+	_refreshStore RefreshTokenStore = NewMemoryRefreshStore()
+	_revoked      RevocationList    = NewMemoryRevocationList()
+)
+
+// TokenPair is a short-lived access token with long-lived opaque refresh token.
+type TokenPair struct {
+	TokenClaims
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+}
+
+// RefreshToken is a stored refresh token record. Token itself is never stored, only its hash.
+type RefreshToken struct {
+	Hash      string
+	Username  string
+	RoleName  string
+	RoleID    int64
+	AccessID  string
+	ExpiresAt time.Time
+}
+
+// RefreshTokenStore persists refresh tokens.
+type RefreshTokenStore interface {
+	// Save stores rt.
+	Save(rt RefreshToken) error
+	// Consume removes and returns token with hash. Each token can be consumed only once.
+	Consume(hash string) (RefreshToken, error)
+	// DeleteUser removes all tokens issued to username.
+	DeleteUser(username string) error
+}
+
+// RevocationList keeps IDs (jti) of revoked access tokens until they expire.
+type RevocationList interface {
+	// Revoke keeps jti on the list at least until the given time. Revoking jti again isn't an error.
+	Revoke(jti string, until time.Time) error
+	IsRevoked(jti string) (bool, error)
+}
+
+// InitRefreshTokens sets stores used for refresh tokens and revoked access tokens.
+// Nil arguments leave the current (in-memory by default) store in place.
+func InitRefreshTokens(store RefreshTokenStore, revoked RevocationList) {
+	if store != nil {
+		_refreshStore = store
+	}
+	if revoked != nil {
+		_revoked = revoked
+	}
+}
+
+// IssueTokenPair returns access token valid for AccessTokenLifetime and refresh token
+// valid for RefreshTokenLifetime.
+func IssueTokenPair(username string, roleName string, roleID int64) (TokenPair, error) {
+	claims, err := NewAuthToken(username, roleName, roleID, WithLifetime(AccessTokenLifetime))
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	rt := RefreshToken{
+		Hash:      hashToken(token),
+		Username:  username,
+		RoleName:  roleName,
+		RoleID:    roleID,
+		AccessID:  claims.Id,
+		ExpiresAt: time.Now().Add(RefreshTokenLifetime),
+	}
+	if err = _refreshStore.Save(rt); err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		TokenClaims:      claims,
+		RefreshToken:     token,
+		RefreshExpiresIn: int64(RefreshTokenLifetime / time.Second),
+	}, nil
+}
+
+// RefreshTokens exchanges refresh token for a new token pair.
+// Refresh token is invalidated and can't be used again.
+func RefreshTokens(refreshToken string) (TokenPair, error) {
+	rt, err := _refreshStore.Consume(hashToken(refreshToken))
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if time.Now().After(rt.ExpiresAt) {
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+
+	return IssueTokenPair(rt.Username, rt.RoleName, rt.RoleID)
+}
+
+// RevokeRefreshToken invalidates refresh token and the access token issued with it.
+func RevokeRefreshToken(refreshToken string) error {
+	rt, err := _refreshStore.Consume(hashToken(refreshToken))
+	if err != nil {
+		return err
+	}
+
+	if rt.AccessID == "" {
+		return nil
+	}
+	return _revoked.Revoke(rt.AccessID, time.Now().Add(AccessTokenLifetime))
+}
+
+// RevokeAuthToken puts claims' token ID on the revocation list until the token expires.
+func RevokeAuthToken(claims *TokenClaims) error {
+	if claims.Id == "" {
+		return ErrTokenHasNoID
+	}
+	return _revoked.Revoke(claims.Id, time.Unix(claims.ExpiresAt, 0))
+}
+
+// RevokeUser invalidates all refresh tokens issued to username.
+func RevokeUser(username string) error {
+	return _refreshStore.DeleteUser(username)
+}
+
+// Logout revokes access token from Authorization header of req and refreshToken, if provided.
+// Principals without token ID (API key, Basic) have nothing to revoke.
+func Logout(req *http.Request, refreshToken string) error {
+	claims, err := RequestClaims(req)
+	if err != nil {
+		return err
+	}
+
+	if err = RevokeAuthToken(claims); err != nil && err != ErrTokenHasNoID {
+		return err
+	}
+
+	if refreshToken != "" {
+		if err = RevokeRefreshToken(refreshToken); err != nil && err != ErrInvalidRefreshToken {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// IsTokenRevoked ...
+func IsTokenRevoked(jti string) (bool, error) {
+	if jti == "" {
+		return false, nil
+	}
+	return _revoked.IsRevoked(jti)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// MemoryRefreshStore ...
+type MemoryRefreshStore struct {
+	mu     sync.Mutex
+	tokens map[string]RefreshToken
+}
+
+// NewMemoryRefreshStore ...
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{
+		tokens: make(map[string]RefreshToken),
+	}
+}
+
+// Save ...
+func (s *MemoryRefreshStore) Save(rt RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range s.tokens {
+		if now.After(v.ExpiresAt) {
+			delete(s.tokens, k)
+		}
+	}
+
+	s.tokens[rt.Hash] = rt
+	return nil
+}
+
+// Consume ...
+func (s *MemoryRefreshStore) Consume(hash string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rt, ok := s.tokens[hash]
+	if !ok {
+		return RefreshToken{}, ErrInvalidRefreshToken
+	}
+	delete(s.tokens, hash)
+
+	return rt, nil
+}
+
+// DeleteUser ...
+func (s *MemoryRefreshStore) DeleteUser(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, v := range s.tokens {
+		if v.Username == username {
+			delete(s.tokens, k)
+		}
+	}
+	return nil
+}
+
+// MemoryRevocationList ...
+type MemoryRevocationList struct {
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+}
+
+// NewMemoryRevocationList ...
+func NewMemoryRevocationList() *MemoryRevocationList {
+	return &MemoryRevocationList{
+		revoked: make(map[string]time.Time),
+	}
+}
+
+// Revoke ...
+func (l *MemoryRevocationList) Revoke(jti string, until time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range l.revoked {
+		if now.After(v) {
+			delete(l.revoked, k)
+		}
+	}
+
+	if l.revoked[jti].Before(until) {
+		l.revoked[jti] = until
+	}
+	return nil
+}
+
+// IsRevoked ...
+func (l *MemoryRevocationList) IsRevoked(jti string) (bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	_, ok := l.revoked[jti]
+	return ok, nil
+}
+
+// SQLRefreshStore stores refresh tokens in a database table:
+//
+//	create table refresh_tokens (
+//	    token_hash varchar(64) primary key,
+//	    username   varchar(255),
+//	    role_name  varchar(255),
+//	    role_id    integer,
+//	    access_id  varchar(64),
+//	    expires_at integer
+//	)
+//
+// Supported drivers are "ora" and "mysql".
+type SQLRefreshStore struct {
+	db    *sql.DB
+	drv   string
+	table string
+}
+
+// NewSQLRefreshStore ...
+func NewSQLRefreshStore(drv string, db *sql.DB, table string) (*SQLRefreshStore, error) {
+	if drv != "ora" && drv != "mysql" {
+		return nil, errors.New("driver not supported")
+	}
+	return &SQLRefreshStore{db: db, drv: drv, table: table}, nil
+}
+
+// Save ...
+func (s *SQLRefreshStore) Save(rt RefreshToken) error {
+	q := fmt.Sprintf("insert into %s(token_hash, username, role_name, role_id, access_id, expires_at) values(%s)",
+		s.table, bindVars(s.drv, 6))
+	_, err := s.db.Exec(q, rt.Hash, rt.Username, rt.RoleName, rt.RoleID, rt.AccessID, rt.ExpiresAt.Unix())
+	return err
+}
+
+// Consume ...
+func (s *SQLRefreshStore) Consume(hash string) (rt RefreshToken, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return rt, err
+	}
+	defer CommitChanges(tx, &err)
+
+	var expires int64
+	q := fmt.Sprintf("select username, role_name, role_id, access_id, expires_at from %s where token_hash = %s",
+		s.table, bindVars(s.drv, 1))
+	err = tx.QueryRow(q, hash).Scan(&rt.Username, &rt.RoleName, &rt.RoleID, &rt.AccessID, &expires)
+	if err == sql.ErrNoRows {
+		return rt, ErrInvalidRefreshToken
+	} else if err != nil {
+		return rt, err
+	}
+
+	res, err := tx.Exec(fmt.Sprintf("delete from %s where token_hash = %s", s.table, bindVars(s.drv, 1)), hash)
+	if err != nil {
+		return rt, err
+	}
+	// someone else consumed the token in the meantime
+	if n, _ := res.RowsAffected(); n != 1 {
+		return rt, ErrInvalidRefreshToken
+	}
+
+	rt.Hash = hash
+	rt.ExpiresAt = time.Unix(expires, 0)
+
+	return rt, nil
+}
+
+// DeleteUser ...
+func (s *SQLRefreshStore) DeleteUser(username string) error {
+	q := fmt.Sprintf("delete from %s where username = %s", s.table, bindVars(s.drv, 1))
+	_, err := s.db.Exec(q, username)
+	return err
+}
+
+// SQLRevocationList stores revoked token IDs in a database table:
+//
+//	create table revoked_tokens (
+//	    jti        varchar(64) primary key,
+//	    expires_at integer
+//	)
+//
+// Supported drivers are "ora" and "mysql".
+type SQLRevocationList struct {
+	db    *sql.DB
+	drv   string
+	table string
+}
+
+// NewSQLRevocationList ...
+func NewSQLRevocationList(drv string, db *sql.DB, table string) (*SQLRevocationList, error) {
+	if drv != "ora" && drv != "mysql" {
+		return nil, errors.New("driver not supported")
+	}
+	return &SQLRevocationList{db: db, drv: drv, table: table}, nil
+}
+
+// Revoke ...
+func (l *SQLRevocationList) Revoke(jti string, until time.Time) error {
+	// clean up entries of tokens that expired on their own
+	q := fmt.Sprintf("delete from %s where expires_at < %s", l.table, bindVars(l.drv, 1))
+	if _, err := l.db.Exec(q, time.Now().Unix()); err != nil {
+		return err
+	}
+
+	revoked, err := l.IsRevoked(jti)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		q = fmt.Sprintf("insert into %s(jti, expires_at) values(%s)", l.table, bindVars(l.drv, 2))
+		if _, err = l.db.Exec(q, jti, until.Unix()); err == nil {
+			return nil
+		}
+		// insert fails on primary key if jti was revoked concurrently
+		if revoked, _ = l.IsRevoked(jti); !revoked {
+			return err
+		}
+	}
+
+	vars := strings.Split(bindVars(l.drv, 3), ", ")
+	q = fmt.Sprintf("update %s set expires_at = %s where jti = %s and expires_at < %s", l.table, vars[0], vars[1], vars[2])
+	_, err = l.db.Exec(q, until.Unix(), jti, until.Unix())
+	return err
+}
+
+// IsRevoked ...
+func (l *SQLRevocationList) IsRevoked(jti string) (bool, error) {
+	var n int64
+	q := fmt.Sprintf("select count(*) from %s where jti = %s", l.table, bindVars(l.drv, 1))
+	if err := l.db.QueryRow(q, jti).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// bindVars returns comma separated list of n bind variables for driver drv.
+func bindVars(drv string, n int) string {
+	vars := make([]string, n)
+	for i := range vars {
+		if drv == "ora" {
+			vars[i] = fmt.Sprintf(":%d", i+1)
+		} else {
+			vars[i] = "?"
+		}
+	}
+	return strings.Join(vars, ", ")
+}
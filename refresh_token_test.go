@@ -0,0 +1,346 @@
+package webutility
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useTokenStores gives the test fresh in-memory refresh token store and revocation list.
+func useTokenStores(t *testing.T) {
+	t.Helper()
+	store, revoked := _refreshStore, _revoked
+	_refreshStore, _revoked = NewMemoryRefreshStore(), NewMemoryRevocationList()
+	t.Cleanup(func() {
+		_refreshStore, _revoked = store, revoked
+	})
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	useKeys(t, NewKeySet(NewHMACKey("", []byte("secret"))))
+	useTokenStores(t)
+
+	pair, err := IssueTokenPair("alice", "admin", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := RefreshTokens(pair.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.RefreshToken == pair.RefreshToken {
+		t.Error("refresh token was not rotated")
+	}
+	if next.Username != "alice" || next.RoleName != "admin" {
+		t.Errorf("claims = %s/%s, want alice/admin", next.Username, next.RoleName)
+	}
+
+	if _, err = RefreshTokens(pair.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("reused refresh token: err = %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err = RefreshTokens(next.RefreshToken); err != nil {
+		t.Errorf("rotated refresh token rejected: %v", err)
+	}
+}
+
+func TestRefreshTokenExpired(t *testing.T) {
+	useKeys(t, NewKeySet(NewHMACKey("", []byte("secret"))))
+	useTokenStores(t)
+
+	lifetime := RefreshTokenLifetime
+	RefreshTokenLifetime = -time.Second
+	defer func() { RefreshTokenLifetime = lifetime }()
+
+	pair, err := IssueTokenPair("alice", "admin", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = RefreshTokens(pair.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("err = %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	useKeys(t, NewKeySet(NewHMACKey("", []byte("secret"))))
+	useTokenStores(t)
+
+	pair, err := IssueTokenPair("alice", "admin", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = RevokeRefreshToken(pair.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = RefreshTokens(pair.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("revoked refresh token: err = %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err = ParseAuthToken(pair.Token); err != ErrTokenRevoked {
+		t.Errorf("access token issued with revoked refresh token: err = %v, want ErrTokenRevoked", err)
+	}
+}
+
+func TestRevokeUser(t *testing.T) {
+	useKeys(t, NewKeySet(NewHMACKey("", []byte("secret"))))
+	useTokenStores(t)
+
+	alice, _ := IssueTokenPair("alice", "admin", 1)
+	bob, _ := IssueTokenPair("bob", "admin", 1)
+
+	if err := RevokeUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RefreshTokens(alice.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("alice: err = %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err := RefreshTokens(bob.RefreshToken); err != nil {
+		t.Errorf("bob: %v", err)
+	}
+}
+
+func TestMemoryRevocationList(t *testing.T) {
+	l := NewMemoryRevocationList()
+
+	if err := l.Revoke("a", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	l.Revoke("expired", time.Now().Add(-time.Second))
+
+	if ok, _ := l.IsRevoked("a"); !ok {
+		t.Error("a is not revoked")
+	}
+	if ok, _ := l.IsRevoked("b"); ok {
+		t.Error("b is revoked")
+	}
+
+	// entries of expired tokens are cleaned up on the next Revoke
+	l.Revoke("c", time.Now().Add(time.Hour))
+	if ok, _ := l.IsRevoked("expired"); ok {
+		t.Error("expired entry was not cleaned up")
+	}
+}
+
+func TestLogout(t *testing.T) {
+	useKeys(t, NewKeySet(NewHMACKey("", []byte("secret"))))
+	useTokenStores(t)
+
+	pair, err := IssueTokenPair("alice", "admin", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+pair.Token)
+	if err = Logout(req, pair.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ParseAuthToken(pair.Token); err != ErrTokenRevoked {
+		t.Errorf("access token: err = %v, want ErrTokenRevoked", err)
+	}
+	if _, err = RefreshTokens(pair.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("refresh token: err = %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestLogoutWithoutTokenID(t *testing.T) {
+	useTokenStores(t)
+
+	for _, scheme := range []string{SchemeAPIKey, SchemeBasic} {
+		req := httptest.NewRequest("POST", "/logout", nil)
+		claims := &TokenClaims{TokenType: scheme, Username: "alice"}
+		req = req.WithContext(ContextWithClaims(req.Context(), claims))
+
+		if err := Logout(req, ""); err != nil {
+			t.Errorf("%s: %v", scheme, err)
+		}
+		if err := RevokeAuthToken(claims); err != ErrTokenHasNoID {
+			t.Errorf("%s: RevokeAuthToken err = %v, want ErrTokenHasNoID", scheme, err)
+		}
+	}
+}
+
+// fakeRevocationDB is a database/sql driver that understands just the statements
+// SQLRevocationList issues with mysql syntax. jti is the primary key as in the documented table.
+type fakeRevocationDB struct {
+	mu   sync.Mutex
+	rows map[string]int64
+}
+
+func (db *fakeRevocationDB) Connect(context.Context) (driver.Conn, error) {
+	return fakeRevocationConn{db}, nil
+}
+func (db *fakeRevocationDB) Driver() driver.Driver { return nil }
+
+type fakeRevocationConn struct{ db *fakeRevocationDB }
+
+func (c fakeRevocationConn) Prepare(q string) (driver.Stmt, error) {
+	return fakeRevocationStmt{c.db, q}, nil
+}
+func (c fakeRevocationConn) Close() error              { return nil }
+func (c fakeRevocationConn) Begin() (driver.Tx, error) { return nil, driver.ErrSkip }
+
+type fakeRevocationStmt struct {
+	db *fakeRevocationDB
+	q  string
+}
+
+func (s fakeRevocationStmt) Close() error  { return nil }
+func (s fakeRevocationStmt) NumInput() int { return -1 }
+
+func (s fakeRevocationStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var n int64
+	switch {
+	case strings.HasPrefix(s.q, "delete"):
+		for jti, exp := range s.db.rows {
+			if exp < args[0].(int64) {
+				delete(s.db.rows, jti)
+				n++
+			}
+		}
+	case strings.HasPrefix(s.q, "insert"):
+		jti := args[0].(string)
+		if _, ok := s.db.rows[jti]; ok {
+			return nil, fmt.Errorf("Error 1062: Duplicate entry '%s' for key 'PRIMARY'", jti)
+		}
+		s.db.rows[jti] = args[1].(int64)
+		n = 1
+	case strings.HasPrefix(s.q, "update"):
+		jti := args[1].(string)
+		if exp, ok := s.db.rows[jti]; ok && exp < args[2].(int64) {
+			s.db.rows[jti] = args[0].(int64)
+			n = 1
+		}
+	default:
+		return nil, fmt.Errorf("unexpected statement: %s", s.q)
+	}
+	return driver.RowsAffected(n), nil
+}
+
+func (s fakeRevocationStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if !strings.HasPrefix(s.q, "select count(*)") {
+		return nil, fmt.Errorf("unexpected query: %s", s.q)
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	var n int64
+	if _, ok := s.db.rows[args[0].(string)]; ok {
+		n = 1
+	}
+	return &fakeCountRows{n: n}, nil
+}
+
+type fakeCountRows struct {
+	n    int64
+	done bool
+}
+
+func (r *fakeCountRows) Columns() []string { return []string{"count"} }
+func (r *fakeCountRows) Close() error      { return nil }
+
+func (r *fakeCountRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.n
+	return nil
+}
+
+func TestSQLRevocationListRevokeTwice(t *testing.T) {
+	fake := &fakeRevocationDB{rows: make(map[string]int64)}
+	db := sql.OpenDB(fake)
+	defer db.Close()
+
+	l, err := NewSQLRevocationList("mysql", db, "revoked_tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	until := time.Now().Add(time.Hour)
+	if err = l.Revoke("a", until); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Revoke("a", until.Add(-time.Minute)); err != nil {
+		t.Errorf("second Revoke: %v", err)
+	}
+	if fake.rows["a"] != until.Unix() {
+		t.Error("second Revoke shortened the entry")
+	}
+	if err = l.Revoke("a", until.Add(time.Hour)); err != nil || fake.rows["a"] != until.Add(time.Hour).Unix() {
+		t.Errorf("Revoke with later time: err = %v, entry not extended", err)
+	}
+	if ok, _ := l.IsRevoked("a"); !ok {
+		t.Error("a is not revoked")
+	}
+}
+
+func TestLogoutSQLRevocationList(t *testing.T) {
+	useKeys(t, NewKeySet(NewHMACKey("", []byte("secret"))))
+	useTokenStores(t)
+
+	db := sql.OpenDB(&fakeRevocationDB{rows: make(map[string]int64)})
+	defer db.Close()
+	l, err := NewSQLRevocationList("mysql", db, "revoked_tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_revoked = l
+
+	pair, err := IssueTokenPair("alice", "admin", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// access token and the one issued with refresh token are the same jti
+	req := httptest.NewRequest("POST", "/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+pair.Token)
+	if err = Logout(req, pair.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseAuthToken(pair.Token); err != ErrTokenRevoked {
+		t.Errorf("access token: err = %v, want ErrTokenRevoked", err)
+	}
+}
+
+func TestRefreshAuthToken(t *testing.T) {
+	useKeys(t, NewKeySet(NewHMACKey("", []byte("secret"))))
+	useTokenStores(t)
+
+	claims, err := NewAuthToken("alice", "admin", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := RefreshAuthToken(claims.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Username != "alice" || next.Id == claims.Id {
+		t.Errorf("got %s/%s", next.Username, next.Id)
+	}
+	if _, err = RefreshAuthToken(claims.Token); err != ErrTokenRevoked {
+		t.Errorf("refreshed token reused: err = %v, want ErrTokenRevoked", err)
+	}
+
+	recent, _ := NewAuthToken("alice", "admin", 1, WithLifetime(-time.Minute))
+	if _, err = RefreshAuthToken(recent.Token); err != nil {
+		t.Errorf("recently expired token: %v", err)
+	}
+
+	old, _ := NewAuthToken("alice", "admin", 1, WithLifetime(-AuthTokenRefreshWindow-time.Minute))
+	if _, err = RefreshAuthToken(old.Token); err == nil {
+		t.Error("token expired before refresh window accepted")
+	}
+}
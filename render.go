@@ -0,0 +1,526 @@
+package webutility
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Encoder writes v to w in some media type.
+type Encoder func(w io.Writer, v interface{}) error
+
+type mediaEncoder struct {
+	mediaType string
+	encode    Encoder
+}
+
+var (
+	encodersMu sync.RWMutex
+	// order matters, first one is used for wildcards
+	encoders = []mediaEncoder{
+		{"application/json", encodeJSON},
+		{"application/xml", encodeXML},
+		{"text/xml", encodeXML},
+		{"text/csv", encodeCSV},
+		{"application/msgpack", encodeMsgpack},
+		{"application/x-msgpack", encodeMsgpack},
+	}
+)
+
+// RegisterEncoder registers enc for mediaType, replacing any existing encoder for it.
+func RegisterEncoder(mediaType string, enc Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+
+	mediaType = strings.ToLower(mediaType)
+	for i := range encoders {
+		if encoders[i].mediaType == mediaType {
+			encoders[i].encode = enc
+			return
+		}
+	}
+	encoders = append(encoders, mediaEncoder{mediaType, enc})
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept returns media ranges from Accept header, including the ones with q=0 which exclude types.
+func parseAccept(accept string) (ranges []acceptRange) {
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qs, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mt, q})
+	}
+	return ranges
+}
+
+// Negotiate returns the registered media type that best matches Accept header of req.
+// Each type gets weight of the most specific range that matches it, so "text/*, text/csv;q=0"
+// accepts any text type but CSV. On equal weights types matched by more specific ranges win,
+// then the ones whose range comes first. Requests without Accept header get application/json.
+func Negotiate(req *http.Request) (mediaType string, ok bool) {
+	accept := req.Header.Get("Accept")
+	if accept == "" {
+		return "application/json", true
+	}
+	ranges := parseAccept(accept)
+
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+
+	var best struct {
+		q           float64
+		specificity int
+		index       int
+	}
+	for _, e := range encoders {
+		specificity, index := -1, 0
+		for i, r := range ranges {
+			if sp := mediaRangeSpecificity(r.mediaType, e.mediaType); sp > specificity {
+				specificity, index = sp, i
+			}
+		}
+		if specificity < 0 || ranges[index].q == 0 {
+			continue
+		}
+
+		q := ranges[index].q
+		if mediaType == "" || q > best.q ||
+			(q == best.q && (specificity > best.specificity || (specificity == best.specificity && index < best.index))) {
+			mediaType = e.mediaType
+			best.q, best.specificity, best.index = q, specificity, index
+		}
+	}
+
+	return mediaType, mediaType != ""
+}
+
+// mediaRangeSpecificity returns 2 if mediaRange is mediaType, 1 if it matches as type/*,
+// 0 for */* and -1 if it doesn't match.
+func mediaRangeSpecificity(mediaRange, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	}
+	return -1
+}
+
+func encoderFor(mediaType string) Encoder {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+
+	for _, e := range encoders {
+		if e.mediaType == mediaType {
+			return e.encode
+		}
+	}
+	return nil
+}
+
+// Render writes payload with status code in the media type client accepts.
+// It responds with 406 Not Acceptable if none of the registered encoders match.
+func Render(w http.ResponseWriter, req *http.Request, status int, payload interface{}) {
+	render(w, req, status, payload, false, false)
+}
+
+// RenderWithETag is like Render but also sets ETag computed from the encoded payload and answers
+// with 304 Not Modified if client already has it. See ComputeETag for weak tags.
+func RenderWithETag(w http.ResponseWriter, req *http.Request, payload interface{}, weak bool) {
+	render(w, req, http.StatusOK, payload, true, weak)
+}
+
+func render(w http.ResponseWriter, req *http.Request, status int, payload interface{}, etag, weak bool) {
+	mediaType, ok := Negotiate(req)
+	if !ok {
+		NotAcceptable(w, req, "none of the accepted media types is supported: "+req.Header.Get("Accept"))
+		return
+	}
+
+	var buf bytes.Buffer
+	if payload != nil {
+		if err := encoderFor(mediaType)(&buf, payload); err != nil {
+			InternalServerError(w, req, err.Error())
+			return
+		}
+	}
+
+	w.Header().Add("Vary", "Accept")
+	if etag && CheckNotModified(w, req, ComputeETag(buf.Bytes(), weak), time.Time{}) {
+		return
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		SetContentType(w, mediaType+"; charset=utf-8")
+	} else {
+		SetContentType(w, mediaType)
+	}
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// NotAcceptable ...
+func NotAcceptable(w http.ResponseWriter, r *http.Request, err string) {
+	SetContentType(w, "application/json")
+	Error(w, r, http.StatusNotAcceptable, err)
+}
+
+// UnsupportedMediaType ...
+func UnsupportedMediaType(w http.ResponseWriter, r *http.Request, err string) {
+	SetContentType(w, "application/json")
+	Error(w, r, http.StatusUnsupportedMediaType, err)
+}
+
+// HasContentType reports whether body of req is one of types. Requests without body always pass.
+func HasContentType(req *http.Request, types ...string) bool {
+	if req.ContentLength == 0 || req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+
+	mt, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, t := range types {
+		if strings.EqualFold(mt, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// toGeneric converts v to generic tree of maps, slices and scalars, as seen by encoding/json.
+func toGeneric(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var out interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	err = dec.Decode(&out)
+
+	return out, err
+}
+
+// encodeXML uses encoding/xml if v supports it, otherwise it writes v's JSON representation as XML.
+func encodeXML(w io.Writer, v interface{}) error {
+	if data, err := xml.Marshal(v); err == nil {
+		w.Write([]byte(xml.Header))
+		_, err = w.Write(data)
+		return err
+	}
+
+	g, err := toGeneric(v)
+	if err != nil {
+		return err
+	}
+
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	if err = writeXMLElement(enc, "response", g); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+func writeXMLElement(enc *xml.Encoder, name string, v interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: xmlName(name)}}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := writeXMLElement(enc, k, t[k]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range t {
+			if err := writeXMLElement(enc, "item", item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		if err := enc.EncodeToken(xml.CharData(fmt.Sprint(t))); err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// xmlName replaces characters not allowed in XML element names.
+func xmlName(s string) string {
+	if s == "" {
+		return "_"
+	}
+	b := []byte(s)
+	for i, c := range b {
+		valid := c == '_' || c == '-' || c == '.' ||
+			(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !valid || (i == 0 && (c == '-' || c == '.' || (c >= '0' && c <= '9'))) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// encodeCSV writes tabular data as CSV. For Payload, Data is written and Fields
+// (if any) determine columns. Otherwise v must be a slice of objects or a single object.
+func encodeCSV(w io.Writer, v interface{}) error {
+	var columns []string
+	switch p := v.(type) {
+	case Payload:
+		v, columns = p.Data, payloadColumns(p.Fields)
+	case *Payload:
+		v, columns = p.Data, payloadColumns(p.Fields)
+	}
+
+	g, err := toGeneric(v)
+	if err != nil {
+		return err
+	}
+
+	var rows []interface{}
+	switch t := g.(type) {
+	case []interface{}:
+		rows = t
+	case map[string]interface{}:
+		rows = []interface{}{t}
+	case nil:
+	default:
+		return fmt.Errorf("csv: unsupported data type %s", reflect.TypeOf(v))
+	}
+
+	if columns == nil {
+		columns = csvColumns(v, rows)
+	}
+
+	cw := csv.NewWriter(w)
+	if err = cw.Write(columns); err != nil {
+		return err
+	}
+
+	record := make([]string, len(columns))
+	for _, r := range rows {
+		m, ok := r.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("csv: rows must be objects")
+		}
+		for i, c := range columns {
+			record[i] = csvValue(m[c])
+		}
+		if err = cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func payloadColumns(fields []Field) []string {
+	var columns []string
+	for _, f := range fields {
+		if f.Visible {
+			columns = append(columns, f.Parameter)
+		}
+	}
+	return columns
+}
+
+// csvColumns returns struct field order if v is a slice of structs, otherwise sorted keys of all rows.
+func csvColumns(v interface{}, rows []interface{}) []string {
+	t := reflect.TypeOf(v)
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		t = t.Elem()
+	}
+	if t != nil && t.Kind() == reflect.Struct && len(rows) > 0 {
+		if first, ok := rows[0].(map[string]interface{}); ok {
+			var columns []string
+			for i := 0; i < t.NumField(); i++ {
+				name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+				if name == "" {
+					name = t.Field(i).Name
+				}
+				if _, ok := first[name]; ok {
+					columns = append(columns, name)
+				}
+			}
+			return columns
+		}
+	}
+
+	seen := make(map[string]bool)
+	var columns []string
+	for _, r := range rows {
+		if m, ok := r.(map[string]interface{}); ok {
+			for k := range m {
+				if !seen[k] {
+					seen[k] = true
+					columns = append(columns, k)
+				}
+			}
+		}
+	}
+	sort.Strings(columns)
+
+	return columns
+}
+
+func csvValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case bool:
+		return strconv.FormatBool(t)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// encodeMsgpack writes v's JSON representation in MessagePack format.
+func encodeMsgpack(w io.Writer, v interface{}) error {
+	g, err := toGeneric(v)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err = writeMsgpack(&buf, g); err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+func writeMsgpack(buf *bytes.Buffer, v interface{}) error {
+	switch t := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if t {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			writeMsgpackInt(buf, i)
+		} else if f, err := t.Float64(); err == nil {
+			buf.WriteByte(0xcb)
+			binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+		} else {
+			return err
+		}
+	case string:
+		writeMsgpackHeader(buf, len(t), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		buf.WriteString(t)
+	case []interface{}:
+		writeMsgpackHeader(buf, len(t), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, item := range t {
+			if err := writeMsgpack(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		writeMsgpackHeader(buf, len(t), 0x80, 15, 0, 0xde, 0xdf)
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			writeMsgpack(buf, k)
+			if err := writeMsgpack(buf, t[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %T", v)
+	}
+	return nil
+}
+
+// writeMsgpackHeader writes length header for str, array or map families.
+// code8 of 0 means the family has no 8-bit length variant.
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fix byte, fixMax int, code8, code16, code32 byte) {
+	switch {
+	case n <= fixMax:
+		buf.WriteByte(fix | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		buf.WriteByte(code8)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(code16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(code32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func writeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= 127:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
@@ -0,0 +1,91 @@
+package webutility
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"application/xml", "application/xml"},
+		{"text/csv;q=0.5, application/xml;q=0.9", "application/xml"},
+		{"application/msgpack;q=0.1, */*;q=0.2", "application/json"},
+		// equal weights: more specific range wins, then the range listed first
+		{"*/*, text/csv", "text/csv"},
+		{"text/*, text/csv", "text/csv"},
+		{"text/csv, application/xml", "text/csv"},
+		{"application/xml, text/csv", "application/xml"},
+		// q=0 excludes types matched by broader ranges
+		{"application/json;q=0, */*", "application/xml"},
+		{"text/csv;q=0, text/*", "text/xml"},
+		{"text/*;q=0, text/csv", "text/csv"},
+		{"text/*, text/xml;q=0, text/csv;q=0", ""},
+		{"image/png", ""},
+		{"application/json;q=0", ""},
+		{"application/json;q=2, text/csv", "text/csv"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		got, ok := Negotiate(req)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("Accept %q: Negotiate() = %q, %v, want %q", tt.accept, got, ok, tt.want)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	type row struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	rows := []row{{"a", 1}, {"b", 2}}
+
+	render := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		Render(w, req, http.StatusOK, rows)
+		return w
+	}
+
+	w := render("application/json")
+	var got []row
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || len(got) != 2 || got[1].Name != "b" {
+		t.Errorf("json: %s", w.Body.String())
+	}
+	if w.Header().Get("Vary") != "Accept" || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("json headers: %v", w.Header())
+	}
+
+	w = render("text/csv")
+	if body := w.Body.String(); body != "name,count\na,1\nb,2\n" {
+		t.Errorf("csv: %q", body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("csv Content-Type = %q", ct)
+	}
+
+	if w = render("application/xml"); !strings.Contains(w.Body.String(), "<Name>a</Name>") {
+		t.Errorf("xml: %s", w.Body.String())
+	}
+
+	// fixarray of 2 fixmaps
+	if w = render("application/msgpack"); len(w.Body.Bytes()) == 0 || w.Body.Bytes()[0] != 0x92 {
+		t.Errorf("msgpack: % x", w.Body.Bytes())
+	}
+
+	if w = render("image/png, application/json;q=0"); w.Code != http.StatusNotAcceptable {
+		t.Errorf("unsupported type: status = %d, want 406", w.Code)
+	}
+}
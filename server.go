@@ -1,9 +1,16 @@
 package webutility
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"git.to-net.rs/marko.tikvic/webutility/logger"
 	"github.com/gorilla/mux"
@@ -16,16 +23,86 @@ type Server struct {
 	Port   string
 	DBs    map[string]*sql.DB
 	dsn    map[string]string
+
+	drivers map[string]string
+
+	// CORS, if set, is applied to all requests served by Run, including preflights for routes
+	// that don't handle OPTIONS. It replaces the global policy of middleware.SetAccessControlHeaders
+	// (and chains built on it, like middleware.Headers) for these requests.
+	CORS *CORSPolicy
+
+	// TLS, if set, makes Run serve HTTPS (and HTTP/2).
+	TLS *TLSConfig
+
+	// HealthDetails makes /readyz (see HandleHealth) report results of individual checks,
+	// including database names and error messages. Enable it only if the endpoint isn't public.
+	HealthDetails bool
+
+	// Timeouts of the underlying http.Server. ReadTimeout also limits reading of request bodies
+	// (uploads), keep it and WriteTimeout at zero if you serve large uploads or SSE streams.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout is how long Run waits for in-flight requests on shutdown.
+	ShutdownTimeout time.Duration
+
+	hubs       []*WSHub
+	onStart    []func() error
+	onShutdown []func(ctx context.Context) error
+	httpServer *http.Server
+	mu         sync.Mutex
+
+	redirectServer *http.Server
+	checks         []namedCheck
+	started        time.Time
+	shuttingDown   bool
+
+	shutdown    sync.Once
+	shutdownErr error
+	cleanup     sync.Once
 }
 
-func NewODBCServer(dsn, port, logDir string) (s *Server, err error) {
+// Default Server timeouts.
+const (
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultIdleTimeout       = 120 * time.Second
+	DefaultShutdownTimeout   = 30 * time.Second
+)
+
+// DBConfig describes a named database connection of Server.
+type DBConfig struct {
+	// Name is used with DBFor, first database is also s.DB. Defaults to "default".
+	Name   string
+	Driver string
+	DSN    string
+
+	// Pool limits, zero values keep database/sql defaults.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	// Lazy skips the connectivity check of AddDB, connection is then made on first use.
+	Lazy bool
+}
+
+// Database connectivity checks done when a database is added to Server.
+var (
+	DBPingRetries  = 5
+	DBPingInterval = 2 * time.Second
+	DBPingTimeout  = 5 * time.Second
+)
+
+// NewServer returns server listening on port with logs in logDir and connections to dbs.
+// Every database that isn't Lazy is pinged, with DBPingRetries retries, before NewServer returns.
+func NewServer(port, logDir string, dbs ...DBConfig) (s *Server, err error) {
 	s = new(Server)
 
 	s.Port = port
-
-	if s.DB, err = sql.Open("odbc", fmt.Sprintf("DSN=%s;", dsn)); err != nil {
-		return nil, err
-	}
+	s.ReadHeaderTimeout = DefaultReadHeaderTimeout
+	s.IdleTimeout = DefaultIdleTimeout
+	s.ShutdownTimeout = DefaultShutdownTimeout
 
 	s.Router = mux.NewRouter()
 
@@ -34,33 +111,320 @@ func NewODBCServer(dsn, port, logDir string) (s *Server, err error) {
 	}
 
 	s.DBs = make(map[string]*sql.DB)
-	s.DBs["default"] = s.DB
-
 	s.dsn = make(map[string]string)
-	s.dsn["default"] = dsn
+	s.drivers = make(map[string]string)
 
+	for _, cfg := range dbs {
+		if err = s.AddDB(cfg); err != nil {
+			s.Cleanup()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// NewODBCServer ...
+func NewODBCServer(dsn, port, logDir string) (s *Server, err error) {
+	s, err = NewServer(port, logDir, DBConfig{Name: "default", Driver: "odbc", DSN: fmt.Sprintf("DSN=%s;", dsn), Lazy: true})
+	if err != nil {
+		return nil, err
+	}
+	s.dsn["default"] = dsn
 	return s, nil
 }
 
+// AddDB opens and, unless cfg.Lazy is set, pings database described with cfg and registers it under cfg.Name.
+// The first database added becomes s.DB.
+func (s *Server) AddDB(cfg DBConfig) error {
+	if cfg.Name == "" {
+		cfg.Name = "default"
+	}
+	if _, ok := s.DBs[cfg.Name]; ok {
+		return fmt.Errorf("database %s already registered", cfg.Name)
+	}
+
+	db, err := sql.Open(cfg.Driver, cfg.DSN)
+	if err != nil {
+		return fmt.Errorf("can't open database %s: %s", cfg.Name, err.Error())
+	}
+
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
+
+	if !cfg.Lazy {
+		if err = pingDB(db, s.Logger, cfg.Name); err != nil {
+			db.Close()
+			return fmt.Errorf("can't connect to database %s: %s", cfg.Name, err.Error())
+		}
+	}
+
+	s.DBs[cfg.Name] = db
+	s.dsn[cfg.Name] = cfg.DSN
+	s.drivers[cfg.Name] = cfg.Driver
+	if s.DB == nil {
+		s.DB = db
+	}
+	return nil
+}
+
+// pingDB pings db until it responds or DBPingRetries are used up. Wait between attempts doubles.
+func pingDB(db *sql.DB, l *logger.Logger, name string) (err error) {
+	wait := DBPingInterval
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), DBPingTimeout)
+		err = db.PingContext(ctx)
+		cancel()
+		if err == nil || attempt >= DBPingRetries {
+			return err
+		}
+
+		if l != nil {
+			l.Print("database %s not available (%s), retrying in %v", name, err.Error(), wait)
+		}
+		time.Sleep(wait)
+		if wait *= 2; wait > 30*time.Second {
+			wait = 30 * time.Second
+		}
+	}
+}
+
+// DBFor returns database registered under name, or nil.
+func (s *Server) DBFor(name string) *sql.DB {
+	return s.DBs[name]
+}
+
+// DriverFor returns driver name of database registered under name.
+func (s *Server) DriverFor(name string) string {
+	return s.drivers[name]
+}
+
+// Run serves requests until SIGINT or SIGTERM and then shuts down gracefully. See RunContext.
 func (s *Server) Run() {
-	s.Logger.Print("Server listening on %s", s.Port)
-	s.Logger.PrintAndTrace(http.ListenAndServe(s.Port, s.Router).Error())
+	if err := s.RunContext(context.Background()); err != nil {
+		// already traced by RunContext, the logger is closed by now
+		s.Logger.Print("%s", err)
+	}
 }
 
-func (s *Server) Cleanup() {
-	if s.DB != nil {
-		s.DB.Close()
+// RunContext runs OnStart hooks and serves requests until ctx is done, SIGINT or SIGTERM is received
+// or Shutdown is called. In-flight requests are then given ShutdownTimeout to finish, OnShutdown hooks
+// are run in reverse order and server's resources are released with Cleanup. Returned error is
+// traced to the server's log before the logger is closed.
+func (s *Server) RunContext(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for _, fn := range s.onStart {
+		if err := fn(); err != nil {
+			err = fmt.Errorf("start hook failed: %s", err.Error())
+			s.traceError(err)
+			s.Cleanup()
+			return err
+		}
+	}
+
+	srv := &http.Server{
+		Addr:              s.Port,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: s.ReadHeaderTimeout,
+		ReadTimeout:       s.ReadTimeout,
+		WriteTimeout:      s.WriteTimeout,
+		IdleTimeout:       s.IdleTimeout,
+	}
+	serve := srv.ListenAndServe
+
+	var redirect *http.Server
+	if s.TLS != nil {
+		cfg, err := s.TLS.serverConfig(ctx, s.Logger)
+		if err != nil {
+			err = fmt.Errorf("can't configure TLS: %s", err.Error())
+			s.traceError(err)
+			s.Cleanup()
+			return err
+		}
+		srv.TLSConfig = cfg
+		serve = func() error {
+			return srv.ListenAndServeTLS("", "")
+		}
+
+		if s.TLS.RedirectAddr != "" {
+			redirect = &http.Server{
+				Addr:              s.TLS.RedirectAddr,
+				Handler:           redirectHandler(s.Port),
+				ReadHeaderTimeout: s.ReadHeaderTimeout,
+				ReadTimeout:       s.ReadTimeout,
+				WriteTimeout:      s.WriteTimeout,
+				IdleTimeout:       s.IdleTimeout,
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.httpServer = srv
+	s.redirectServer = redirect
+	if s.started.IsZero() {
+		s.started = time.Now()
 	}
+	s.mu.Unlock()
 
+	errc := make(chan error, 2)
+	go func() {
+		s.Logger.Print("Server listening on %s", s.Port)
+		errc <- serve()
+	}()
+	if redirect != nil {
+		go func() {
+			s.Logger.Print("Redirecting %s to HTTPS", redirect.Addr)
+			errc <- redirect.ListenAndServe()
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-errc:
+		// failed to start or Shutdown was called
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+	case <-ctx.Done():
+		s.Logger.Print("Server shutting down")
+	}
+	if err != nil {
+		s.traceError(err)
+	}
+
+	if serr := s.Shutdown(); serr != nil && err == nil {
+		err = serr
+	}
+	return err
+}
+
+// traceError writes err to the server's log. It must be called before Cleanup closes the logger.
+func (s *Server) traceError(err error) {
 	if s.Logger != nil {
-		s.Logger.Close()
+		s.Logger.Trace("%s", err)
+	}
+}
+
+// Shutdown stops accepting new connections, waits up to ShutdownTimeout for in-flight requests,
+// runs OnShutdown hooks and calls Cleanup. Concurrent calls wait for the first one to finish.
+func (s *Server) Shutdown() error {
+	s.shutdown.Do(func() {
+		s.shutdownErr = s.doShutdown()
+	})
+	return s.shutdownErr
+}
+
+func (s *Server) doShutdown() error {
+	timeout := s.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	s.mu.Lock()
+	srv := s.httpServer
+	redirect := s.redirectServer
+	hooks := s.onShutdown
+	s.shuttingDown = true
+	s.mu.Unlock()
+
+	if redirect != nil {
+		redirect.Shutdown(ctx)
+	}
+
+	var err error
+	if srv != nil {
+		// hijacked websocket connections aren't tracked by http.Server
+		for _, h := range s.hubs {
+			h.Close()
+		}
+		if err = srv.Shutdown(ctx); err != nil {
+			s.traceError(err)
+			srv.Close()
+		}
+	}
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if herr := hooks[i](ctx); herr != nil {
+			s.Logger.Print("shutdown hook failed: %s", herr.Error())
+			s.traceError(herr)
+			if err == nil {
+				err = herr
+			}
+		}
+	}
+
+	s.Cleanup()
+	return err
+}
+
+// OnStart registers fn to be run by Run before the server starts listening.
+// Run fails if fn returns an error.
+func (s *Server) OnStart(fn func() error) {
+	s.onStart = append(s.onStart, fn)
+}
+
+// OnShutdown registers fn to be run on shutdown, after in-flight requests finished and
+// before databases and the logger are closed. Hooks run in reverse order of registration.
+func (s *Server) OnShutdown(fn func(ctx context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onShutdown = append(s.onShutdown, fn)
+}
+
+// Handler returns s.Router wrapped with server-wide middleware.
+func (s *Server) Handler() http.Handler {
+	var h http.Handler = s.Router
+	if s.CORS != nil {
+		h = s.CORS.Handler(h)
+	}
+	return h
+}
+
+// Cleanup stops hotloading and closes websocket hubs, all databases and the logger.
+// It's called by Run on shutdown and is safe to call more than once.
+func (s *Server) Cleanup() {
+	s.cleanup.Do(func() {
+		for _, h := range s.hubs {
+			h.Close()
+		}
+
+		DisableHotloading()
+
+		if s.DB != nil {
+			s.DB.Close()
+		}
+		for _, db := range s.DBs {
+			if db != s.DB {
+				db.Close()
+			}
+		}
+
+		if s.Logger != nil {
+			s.Logger.Close()
+		}
+	})
 }
 
 func (s *Server) StartTransaction() (*sql.Tx, error) {
 	return s.DB.Begin()
 }
 
+// CommitChanges commits tx if err and all opt errors are nil, otherwise it rolls it back.
+// See Server.WithTx for a safer alternative.
 func CommitChanges(tx *sql.Tx, err *error, opt ...error) {
 	if *err != nil {
 		tx.Rollback()
@@ -0,0 +1,135 @@
+package webutility
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// countingDriver counts connection attempts, all of which fail.
+type countingDriver struct {
+	opens int32
+}
+
+func (d *countingDriver) Open(name string) (driver.Conn, error) {
+	atomic.AddInt32(&d.opens, 1)
+	return nil, errors.New("database unavailable")
+}
+
+// testDrivers are registered once per test binary, sql.Register panics on duplicates.
+var testDrivers = map[string]*countingDriver{
+	"odbc":      {},
+	"test-pg":   {},
+	"test-fail": {},
+}
+
+func init() {
+	for name, d := range testDrivers {
+		sql.Register(name, d)
+	}
+}
+
+func TestRunContextTracesError(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewServer(":0", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.OnStart(func() error { return errors.New("100% broken") })
+
+	if err := s.RunContext(context.Background()); err == nil || !strings.Contains(err.Error(), "100% broken") {
+		t.Fatalf("RunContext() = %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "err *.txt"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("log files = %v, %v", files, err)
+	}
+	b, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "start hook failed: 100% broken") {
+		t.Errorf("log = %q", b)
+	}
+}
+
+func TestNewODBCServerIsLazy(t *testing.T) {
+	d := testDrivers["odbc"]
+	before := atomic.LoadInt32(&d.opens)
+
+	s, err := NewODBCServer("reports", ":0", t.TempDir())
+	if err != nil {
+		t.Fatalf("NewODBCServer() = %v", err)
+	}
+	defer s.Cleanup()
+
+	if got := atomic.LoadInt32(&d.opens) - before; got != 0 {
+		t.Errorf("NewODBCServer connected %d times, want 0", got)
+	}
+	if s.DB == nil || s.DBFor("default") != s.DB {
+		t.Errorf("DB = %v, DBFor(default) = %v", s.DB, s.DBFor("default"))
+	}
+	if got := s.DriverFor("default"); got != "odbc" {
+		t.Errorf("DriverFor(default) = %q, want odbc", got)
+	}
+
+	// connection is made on first use
+	if err := s.DB.Ping(); err == nil {
+		t.Error("Ping() = nil, want error")
+	}
+	if atomic.LoadInt32(&d.opens) == before {
+		t.Error("Ping() didn't connect")
+	}
+}
+
+func TestServerDBs(t *testing.T) {
+	prev := DBPingRetries
+	DBPingRetries = 0
+	defer func() { DBPingRetries = prev }()
+
+	s, err := NewServer(":0", t.TempDir(),
+		DBConfig{Driver: "test-pg", DSN: "main", Lazy: true},
+		DBConfig{Name: "reports", Driver: "odbc", DSN: "DSN=reports;", Lazy: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+
+	tests := []struct {
+		name   string
+		driver string
+	}{
+		{"default", "test-pg"},
+		{"reports", "odbc"},
+		{"unknown", ""},
+	}
+	for _, tt := range tests {
+		if got := s.DriverFor(tt.name); got != tt.driver {
+			t.Errorf("DriverFor(%s) = %q, want %q", tt.name, got, tt.driver)
+		}
+		if db := s.DBFor(tt.name); (db != nil) != (tt.driver != "") {
+			t.Errorf("DBFor(%s) = %v", tt.name, db)
+		}
+	}
+	if s.DB != s.DBFor("default") || s.DB == s.DBFor("reports") {
+		t.Error("s.DB isn't the first database")
+	}
+
+	if err := s.AddDB(DBConfig{Name: "reports", Driver: "test-pg", Lazy: true}); err == nil {
+		t.Error("AddDB() with registered name = nil, want error")
+	}
+	if err := s.AddDB(DBConfig{Name: "down", Driver: "test-fail"}); err == nil {
+		t.Error("AddDB() of unavailable database = nil, want error")
+	}
+	if s.DBFor("down") != nil {
+		t.Error("unavailable database was registered")
+	}
+}
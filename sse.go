@@ -0,0 +1,296 @@
+package webutility
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSSEHeartbeat is interval of keep-alive comments sent to idle SSE clients.
+const DefaultSSEHeartbeat = 15 * time.Second
+
+// ErrStreamingUnsupported is returned when response writer can't be flushed.
+var ErrStreamingUnsupported = errors.New("streaming unsupported")
+
+// Event is a server-sent event.
+type Event struct {
+	ID    string
+	Topic string
+	// Event is event type, "message" if empty. Line breaks are removed.
+	Event string
+	// Data is sent as is if it's a string or []byte, otherwise as JSON.
+	Data interface{}
+
+	seq uint64
+}
+
+// SSEBroker fans out published events to clients subscribed to topics, for example
+// object types of lists with LiveGraph enabled.
+type SSEBroker struct {
+	// Heartbeat is interval of keep-alive comments. Zero disables heartbeats.
+	Heartbeat time.Duration
+	// ClientBuffer is number of events queued per client. Clients that fall behind are
+	// disconnected and catch up with Last-Event-ID when they reconnect.
+	ClientBuffer int
+
+	mu         sync.Mutex
+	seq        uint64
+	bufferSize int
+	topics     map[string]*sseTopic
+	closed     chan struct{}
+}
+
+type sseTopic struct {
+	clients map[*sseClient]struct{}
+	history []Event
+}
+
+type sseClient struct {
+	events chan Event
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (c *sseClient) drop() {
+	c.once.Do(func() { close(c.done) })
+}
+
+// NewSSEBroker returns broker that keeps last bufferSize events of each topic for Last-Event-ID replay.
+func NewSSEBroker(bufferSize int) *SSEBroker {
+	return &SSEBroker{
+		Heartbeat:    DefaultSSEHeartbeat,
+		ClientBuffer: 16,
+		bufferSize:   bufferSize,
+		topics:       make(map[string]*sseTopic),
+		closed:       make(chan struct{}),
+	}
+}
+
+func (b *SSEBroker) topic(name string) *sseTopic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &sseTopic{clients: make(map[*sseClient]struct{})}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// Publish sends event with data to all clients subscribed to topic and returns event's ID.
+func (b *SSEBroker) Publish(topic, event string, data interface{}) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	ev := Event{
+		ID:    strconv.FormatUint(b.seq, 10),
+		Topic: topic,
+		Event: event,
+		Data:  data,
+		seq:   b.seq,
+	}
+
+	t := b.topic(topic)
+	if b.bufferSize > 0 {
+		if len(t.history) == b.bufferSize {
+			copy(t.history, t.history[1:])
+			t.history = t.history[:len(t.history)-1]
+		}
+		t.history = append(t.history, ev)
+	}
+
+	for c := range t.clients {
+		select {
+		case c.events <- ev:
+		default:
+			// slow client, it will replay missed events on reconnect
+			c.drop()
+			delete(t.clients, c)
+		}
+	}
+
+	return ev.ID
+}
+
+// Subscribers returns number of clients subscribed to topic.
+func (b *SSEBroker) Subscribers(topic string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if t, ok := b.topics[topic]; ok {
+		return len(t.clients)
+	}
+	return 0
+}
+
+// subscribe registers new client and returns buffered events newer than lastID.
+func (b *SSEBroker) subscribe(topics []string, lastID uint64) (*sseClient, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := &sseClient{
+		events: make(chan Event, b.ClientBuffer),
+		done:   make(chan struct{}),
+	}
+
+	var replay []Event
+	for _, name := range topics {
+		t := b.topic(name)
+		t.clients[c] = struct{}{}
+		if lastID == 0 {
+			continue
+		}
+		for _, ev := range t.history {
+			if ev.seq > lastID {
+				replay = append(replay, ev)
+			}
+		}
+	}
+	if len(topics) > 1 {
+		sortEvents(replay)
+	}
+
+	return c, replay
+}
+
+func (b *SSEBroker) unsubscribe(c *sseClient, topics []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, name := range topics {
+		if t, ok := b.topics[name]; ok {
+			delete(t.clients, c)
+			if len(t.clients) == 0 && len(t.history) == 0 {
+				delete(b.topics, name)
+			}
+		}
+	}
+}
+
+func sortEvents(evs []Event) {
+	// replays are short, insertion sort keeps it simple
+	for i := 1; i < len(evs); i++ {
+		for j := i; j > 0 && evs[j].seq < evs[j-1].seq; j-- {
+			evs[j], evs[j-1] = evs[j-1], evs[j]
+		}
+	}
+}
+
+// Serve streams events of topics to the client until it disconnects or broker is closed.
+// Events the client missed (see Last-Event-ID header) are replayed first, if still buffered.
+func (b *SSEBroker) Serve(w http.ResponseWriter, req *http.Request, topics ...string) error {
+	if !canFlush(w) {
+		return ErrStreamingUnsupported
+	}
+	flusher := w.(http.Flusher)
+
+	lastID, _ := strconv.ParseUint(req.Header.Get("Last-Event-ID"), 10, 64)
+	c, replay := b.subscribe(topics, lastID)
+	defer b.unsubscribe(c, topics)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, ev := range replay {
+		if err := writeEvent(w, ev); err != nil {
+			return err
+		}
+	}
+	flusher.Flush()
+
+	var heartbeat <-chan time.Time
+	if b.Heartbeat > 0 {
+		t := time.NewTicker(b.Heartbeat)
+		defer t.Stop()
+		heartbeat = t.C
+	}
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return nil
+		case <-b.closed:
+			return nil
+		case <-c.done:
+			return nil
+		case ev := <-c.events:
+			if err := writeEvent(w, ev); err != nil {
+				return err
+			}
+			flusher.Flush()
+		case <-heartbeat:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// Handler returns handler that subscribes clients to topics returned by topics function,
+// for example request's "topic" query values.
+func (b *SSEBroker) Handler(topics func(req *http.Request) []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		names := topics(req)
+		if len(names) == 0 {
+			BadRequest(w, req, "no topics")
+			return
+		}
+		if err := b.Serve(w, req, names...); err == ErrStreamingUnsupported {
+			InternalServerError(w, req, err.Error())
+		}
+	}
+}
+
+// Close disconnects all clients.
+func (b *SSEBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	select {
+	case <-b.closed:
+	default:
+		close(b.closed)
+	}
+}
+
+// sseLineBreaks removes line breaks from single line fields, which would otherwise start new fields.
+var sseLineBreaks = strings.NewReplacer("\r", "", "\n", "")
+
+func writeEvent(w http.ResponseWriter, ev Event) error {
+	var data []byte
+	switch d := ev.Data.(type) {
+	case string:
+		data = []byte(d)
+	case []byte:
+		data = d
+	default:
+		var err error
+		if data, err = json.Marshal(d); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("id: " + sseLineBreaks.Replace(ev.ID) + "\n")
+	if ev.Event != "" {
+		buf.WriteString("event: " + sseLineBreaks.Replace(ev.Event) + "\n")
+	}
+	// CR and CRLF end lines too, data lines are split on all of them
+	text := strings.Replace(string(data), "\r\n", "\n", -1)
+	for _, line := range strings.Split(strings.Replace(text, "\r", "\n", -1), "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
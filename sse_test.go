@@ -0,0 +1,72 @@
+package webutility
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteEventStripsLineBreaks(t *testing.T) {
+	w := httptest.NewRecorder()
+	ev := Event{ID: "1\nretry: 1", Event: "update\r\ndata: injected", Data: "a\r\nb\rc\nd"}
+	if err := writeEvent(w, ev); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "id: 1retry: 1\n" +
+		"event: updatedata: injected\n" +
+		"data: a\ndata: b\ndata: c\ndata: d\n\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("event = %q, want %q", got, want)
+	}
+}
+
+func TestSSEBrokerReplay(t *testing.T) {
+	b := NewSSEBroker(10)
+	b.Heartbeat = 0
+	defer b.Close()
+
+	b.Publish("orders", "created", map[string]int{"id": 1})
+	second := b.Publish("orders", "created", map[string]int{"id": 2})
+	b.Publish("users", "created", "ignored")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/events", nil).WithContext(ctx)
+	req.Header.Set("Last-Event-ID", "1")
+	w := httptest.NewRecorder()
+
+	done := make(chan error, 1)
+	go func() { done <- b.Serve(w, req, "orders") }()
+
+	deadline := time.Now().Add(time.Second)
+	for b.Subscribers("orders") == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	body := w.Body.String()
+	if strings.Contains(body, `"id":1`) || !strings.Contains(body, "id: "+second+"\n") {
+		t.Errorf("replay = %q, want only event %s", body, second)
+	}
+	if strings.Contains(body, "ignored") {
+		t.Errorf("event of other topic replayed: %q", body)
+	}
+}
+
+func TestSSEBrokerStreamingUnsupported(t *testing.T) {
+	b := NewSSEBroker(0)
+	defer b.Close()
+
+	w := NewStatusRecorder(plainWriter{httptest.NewRecorder()})
+	if err := b.Serve(w, httptest.NewRequest("GET", "/", nil), "orders"); err != ErrStreamingUnsupported {
+		t.Errorf("err = %v, want ErrStreamingUnsupported", err)
+	}
+	if w.Size() != 0 || w.Header().Get("Content-Type") != "" {
+		t.Errorf("response was started: size %d, headers %v", w.Size(), w.Header())
+	}
+}
@@ -0,0 +1,235 @@
+package webutility
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"git.to-net.rs/marko.tikvic/webutility/logger"
+)
+
+// DefaultCertReloadInterval is how often certificate files are checked for changes.
+const DefaultCertReloadInterval = time.Minute
+
+// TLSConfig configures HTTPS serving of Server. HTTP/2 is enabled automatically.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile is PEM bundle of CAs that sign client certificates. Setting it enables mTLS,
+	// see ClientAuth.
+	ClientCAFile string
+	// ClientAuth defaults to tls.RequireAndVerifyClientCert when ClientCAFile is set.
+	ClientAuth tls.ClientAuthType
+
+	// ReloadInterval is how often CertFile and KeyFile are checked for changes.
+	// Defaults to DefaultCertReloadInterval, negative disables reloading.
+	ReloadInterval time.Duration
+
+	// RedirectAddr, if set, is plaintext address (e.g. ":80") that redirects all requests to HTTPS.
+	RedirectAddr string
+
+	// MinVersion defaults to TLS 1.2.
+	MinVersion uint16
+}
+
+// serverConfig returns tls.Config described by c. Certificates are reloaded until ctx is done.
+func (c *TLSConfig) serverConfig(ctx context.Context, l *logger.Logger) (*tls.Config, error) {
+	reloader, err := NewCertReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := c.ReloadInterval
+	if interval == 0 {
+		interval = DefaultCertReloadInterval
+	}
+	if interval > 0 {
+		go reloader.Watch(ctx, interval, func(err error) {
+			if l != nil {
+				l.Print("certificate reload failed: %s", err.Error())
+			}
+		})
+	}
+
+	cfg := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     c.MinVersion,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+
+	if c.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = c.ClientAuth
+		if cfg.ClientAuth == tls.NoClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return cfg, nil
+}
+
+// CertReloader serves certificate loaded from files and reloads it when files change.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewCertReloader loads certificate from certFile and keyFile.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Reload loads certificate again if the files changed since the last load.
+// It returns whether certificate was replaced. On error the current certificate is kept.
+func (r *CertReloader) Reload() (bool, error) {
+	mod, err := r.lastModified()
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	unchanged := r.cert != nil && mod.Equal(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = mod
+	r.mu.Unlock()
+	return true, nil
+}
+
+// Watch calls Reload every interval until ctx is done. Errors are passed to onError, if not nil.
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := r.Reload(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// ClientIdentity is identity from verified mTLS client certificate.
+type ClientIdentity struct {
+	CommonName     string
+	Organization   []string
+	DNSNames       []string
+	EmailAddresses []string
+	SerialNumber   string
+	Certificate    *x509.Certificate
+}
+
+// RequestClientIdentity returns identity of the client that sent req with a verified certificate.
+func RequestClientIdentity(req *http.Request) (*ClientIdentity, bool) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+
+	cert := req.TLS.VerifiedChains[0][0]
+	return &ClientIdentity{
+		CommonName:     cert.Subject.CommonName,
+		Organization:   cert.Subject.Organization,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		SerialNumber:   cert.SerialNumber.String(),
+		Certificate:    cert,
+	}, true
+}
+
+// ClientCertAuth authenticates requests with verified client certificates. Identity is mapped to
+// claims with mapper, which should return ErrInvalidCredentials for unknown clients.
+func ClientCertAuth(mapper func(id *ClientIdentity) (*TokenClaims, error)) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) (*TokenClaims, error) {
+		id, ok := RequestClientIdentity(req)
+		if !ok {
+			return nil, ErrNoCredentials
+		}
+		return mapper(id)
+	})
+}
+
+// redirectHandler redirects requests to HTTPS on tlsAddr's port.
+func redirectHandler(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			// IPv6 literal without port
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + req.URL.RequestURI()
+		http.Redirect(w, req, target, http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,143 @@
+package webutility
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes self-signed certificate for commonName and its key to certFile and keyFile,
+// with modification time mod.
+func writeCert(t *testing.T, certFile, keyFile, commonName string, mod time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), mod)
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), mod)
+}
+
+func writeFile(t *testing.T, name string, data []byte, mod time.Time) {
+	t.Helper()
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func servedCommonName(t *testing.T, r *CertReloader) string {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	mod := time.Now().Add(-time.Hour)
+	writeCert(t, certFile, keyFile, "v1", mod)
+
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn := servedCommonName(t, r); cn != "v1" {
+		t.Fatalf("served %s, want v1", cn)
+	}
+
+	if reloaded, err := r.Reload(); reloaded || err != nil {
+		t.Errorf("unchanged files: Reload() = %v, %v", reloaded, err)
+	}
+
+	mod = mod.Add(time.Minute)
+	writeCert(t, certFile, keyFile, "v2", mod)
+	if reloaded, err := r.Reload(); !reloaded || err != nil {
+		t.Fatalf("changed files: Reload() = %v, %v", reloaded, err)
+	}
+	if cn := servedCommonName(t, r); cn != "v2" {
+		t.Errorf("served %s, want v2", cn)
+	}
+
+	// broken files keep the current certificate
+	writeFile(t, keyFile, []byte("not a key"), mod.Add(time.Minute))
+	if reloaded, err := r.Reload(); reloaded || err == nil {
+		t.Errorf("broken key: Reload() = %v, %v", reloaded, err)
+	}
+	if cn := servedCommonName(t, r); cn != "v2" {
+		t.Errorf("served %s, want v2", cn)
+	}
+
+	os.Remove(keyFile)
+	if _, err := r.Reload(); err == nil {
+		t.Error("missing key: Reload() = nil, want error")
+	}
+	if _, err := NewCertReloader(certFile, keyFile); err == nil {
+		t.Error("missing key: NewCertReloader() = nil, want error")
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		tlsAddr string
+		host    string
+		want    string
+	}{
+		{":443", "example.com", "https://example.com/path?q=1"},
+		{":443", "example.com:80", "https://example.com/path?q=1"},
+		{":8443", "example.com:8080", "https://example.com:8443/path?q=1"},
+		{"127.0.0.1:8443", "example.com", "https://example.com:8443/path?q=1"},
+		{":8443", "[::1]:8080", "https://[::1]:8443/path?q=1"},
+		{":443", "[::1]:80", "https://[::1]/path?q=1"},
+		{":443", "[::1]", "https://[::1]/path?q=1"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "http://"+tt.host+"/path?q=1", nil)
+		req.Host = tt.host
+		w := httptest.NewRecorder()
+		redirectHandler(tt.tlsAddr).ServeHTTP(w, req)
+
+		if w.Code != http.StatusPermanentRedirect {
+			t.Errorf("%s, %s: status = %d, want 308", tt.tlsAddr, tt.host, w.Code)
+		}
+		if got := w.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s, %s: Location = %q, want %q", tt.tlsAddr, tt.host, got, tt.want)
+		}
+	}
+}
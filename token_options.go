@@ -0,0 +1,195 @@
+package webutility
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// DefaultTokenLifetime is the lifetime of tokens created by CreateAuthToken.
+var DefaultTokenLifetime = time.Hour * 24 * 7
+
+var _audience string
+
+// SetJWTAudience sets audience claim of created tokens. When set, parsed tokens must carry it.
+func SetJWTAudience(aud string) {
+	_audience = aud
+}
+
+type tokenBuilder struct {
+	claims   TokenClaims
+	lifetime time.Duration
+}
+
+// TokenOption configures a token created by NewAuthToken.
+type TokenOption func(*tokenBuilder)
+
+// WithLifetime sets token expiration date to d after it was issued.
+func WithLifetime(d time.Duration) TokenOption {
+	return func(b *tokenBuilder) {
+		b.lifetime = d
+	}
+}
+
+// WithAudience overrides audience set by SetJWTAudience.
+func WithAudience(aud string) TokenOption {
+	return func(b *tokenBuilder) {
+		b.claims.Audience = aud
+	}
+}
+
+// WithSubject ...
+func WithSubject(sub string) TokenOption {
+	return func(b *tokenBuilder) {
+		b.claims.Subject = sub
+	}
+}
+
+// WithNotBefore sets the time before which the token is not valid.
+func WithNotBefore(t time.Time) TokenOption {
+	return func(b *tokenBuilder) {
+		b.claims.NotBefore = t.Unix()
+	}
+}
+
+// WithClaim adds custom claim key with value v. Custom claims are stored under "ext" claim.
+func WithClaim(key string, v interface{}) TokenOption {
+	return func(b *tokenBuilder) {
+		if b.claims.Extra == nil {
+			b.claims.Extra = make(map[string]interface{})
+		}
+		b.claims.Extra[key] = v
+	}
+}
+
+// WithClaims adds all custom claims from m.
+func WithClaims(m map[string]interface{}) TokenOption {
+	return func(b *tokenBuilder) {
+		for k, v := range m {
+			WithClaim(k, v)(b)
+		}
+	}
+}
+
+// NewAuthToken returns signed JWT token for username with role and claims configured by opts.
+// Every token gets a unique ID (jti).
+func NewAuthToken(username string, roleName string, roleID int64, opts ...TokenOption) (TokenClaims, error) {
+	b := &tokenBuilder{lifetime: DefaultTokenLifetime}
+	b.claims.Audience = _audience
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	jti, err := randomToken(16)
+	if err != nil {
+		return TokenClaims{}, err
+	}
+
+	t0 := time.Now()
+	t1 := t0.Add(b.lifetime)
+
+	claims := b.claims
+	claims.TokenType = SchemeBearer
+	claims.Username = username
+	claims.RoleName = roleName
+	claims.RoleID = roleID
+	claims.ExpiresIn = t1.Unix() - t0.Unix()
+	// initialize jwt.StandardClaims fields (anonymous struct)
+	claims.IssuedAt = t0.Unix()
+	claims.ExpiresAt = t1.Unix()
+	claims.Issuer = _issuer
+	claims.Id = jti
+
+	token, err := _keys.Sign(claims)
+	if err != nil {
+		return TokenClaims{}, err
+	}
+	claims.Token = token
+
+	return claims, nil
+}
+
+type parseOptions struct {
+	keyfunc      jwt.Keyfunc
+	allowExpired bool
+	issuer       string
+	audience     string
+}
+
+// ParseOption configures ParseAuthToken and DecodeJWT.
+type ParseOption func(*parseOptions)
+
+// AllowExpired makes parser accept tokens that have expired.
+func AllowExpired() ParseOption {
+	return func(o *parseOptions) {
+		o.allowExpired = true
+	}
+}
+
+// ExpectIssuer requires token to be issued by iss.
+func ExpectIssuer(iss string) ParseOption {
+	return func(o *parseOptions) {
+		o.issuer = iss
+	}
+}
+
+// ExpectAudience requires token to be issued for aud.
+func ExpectAudience(aud string) ParseOption {
+	return func(o *parseOptions) {
+		o.audience = aud
+	}
+}
+
+// ParseAuthToken validates signature of tok and returns its claims.
+// Issuer and audience must match the ones set by InitJWT and SetJWTAudience
+// unless overridden with opts. Revoked tokens are rejected.
+func ParseAuthToken(tok string, opts ...ParseOption) (*TokenClaims, error) {
+	o := parseOptions{
+		keyfunc:  _keys.Keyfunc,
+		issuer:   _issuer,
+		audience: _audience,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	claims, err := parseToken(tok, o)
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked, err := IsTokenRevoked(claims.Id); err != nil {
+		return nil, err
+	} else if revoked {
+		return nil, ErrTokenRevoked
+	}
+
+	return claims, nil
+}
+
+func parseToken(tok string, o parseOptions) (*TokenClaims, error) {
+	token, err := jwt.ParseWithClaims(tok, &TokenClaims{}, o.keyfunc)
+	if err != nil {
+		validation, ok := err.(*jwt.ValidationError)
+		if !ok || !o.allowExpired || validation.Errors&^jwt.ValidationErrorExpired != 0 {
+			return nil, err
+		}
+	}
+
+	// type assertion
+	claims, ok := token.Claims.(*TokenClaims)
+	if !ok {
+		return nil, errors.New("token is not valid")
+	}
+
+	if o.issuer != "" && claims.Issuer != o.issuer {
+		return nil, errors.New("token issuer is not valid")
+	}
+
+	if o.audience != "" && claims.Audience != o.audience {
+		return nil, errors.New("token audience is not valid")
+	}
+
+	return claims, nil
+}
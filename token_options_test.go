@@ -0,0 +1,93 @@
+package webutility
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenClaimsRoundTrip(t *testing.T) {
+	useKeys(t, NewKeySet(NewHMACKey("", []byte("secret"))))
+
+	tok, err := NewAuthToken("alice", "admin", 1,
+		WithClaim("tenant", "acme"),
+		WithClaims(map[string]interface{}{"level": 3, "beta": true}),
+		WithSubject("user-1"),
+		WithLifetime(time.Minute),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ParseAuthToken(tok.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Username != "alice" || claims.RoleName != "admin" || claims.RoleID != 1 || claims.Subject != "user-1" {
+		t.Errorf("claims = %+v", claims)
+	}
+	if claims.Id == "" || claims.Id != tok.Id {
+		t.Errorf("jti = %q, want %q", claims.Id, tok.Id)
+	}
+	if claims.ExpiresAt-claims.IssuedAt != 60 {
+		t.Errorf("lifetime = %ds, want 60s", claims.ExpiresAt-claims.IssuedAt)
+	}
+	// custom claims come back as JSON values
+	if claims.Extra["tenant"] != "acme" || claims.Extra["level"] != float64(3) || claims.Extra["beta"] != true {
+		t.Errorf("Extra = %v", claims.Extra)
+	}
+}
+
+func TestParseAuthTokenIssuerAndAudience(t *testing.T) {
+	useKeys(t, NewKeySet(NewHMACKey("", []byte("secret"))))
+	aud := _audience
+	defer SetJWTAudience(aud)
+	SetJWTAudience("api")
+
+	tok, err := NewAuthToken("alice", "admin", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseAuthToken(tok.Token); err != nil {
+		t.Errorf("own token rejected: %v", err)
+	}
+
+	other, _ := NewAuthToken("alice", "admin", 1, WithAudience("billing"))
+	if _, err = ParseAuthToken(other.Token); err == nil {
+		t.Error("token for other audience accepted")
+	}
+	if _, err = ParseAuthToken(other.Token, ExpectAudience("billing")); err != nil {
+		t.Errorf("ExpectAudience: %v", err)
+	}
+
+	if _, err = ParseAuthToken(tok.Token, ExpectIssuer("someone-else")); err == nil {
+		t.Error("token of other issuer accepted")
+	}
+	_issuer = "someone-else"
+	if _, err = ParseAuthToken(tok.Token); err == nil {
+		t.Error("token of other issuer accepted after InitJWT")
+	}
+}
+
+func TestParseAuthTokenExpired(t *testing.T) {
+	useKeys(t, NewKeySet(NewHMACKey("", []byte("secret"))))
+
+	tok, err := NewAuthToken("alice", "admin", 1, WithLifetime(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseAuthToken(tok.Token); err == nil {
+		t.Error("expired token accepted")
+	}
+	if claims, err := ParseAuthToken(tok.Token, AllowExpired()); err != nil || claims.Username != "alice" {
+		t.Errorf("AllowExpired: claims = %+v, err = %v", claims, err)
+	}
+
+	// AllowExpired doesn't relax other checks
+	notYet, _ := NewAuthToken("alice", "admin", 1, WithNotBefore(time.Now().Add(time.Hour)))
+	if _, err = ParseAuthToken(notYet.Token, AllowExpired()); err == nil {
+		t.Error("token not valid yet accepted with AllowExpired")
+	}
+	if _, err = ParseAuthToken(tok.Token+"x", AllowExpired()); err == nil {
+		t.Error("token with bad signature accepted with AllowExpired")
+	}
+}
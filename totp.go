@@ -0,0 +1,198 @@
+package webutility
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters as expected by common authenticator apps.
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30
+)
+
+// PartialTokenLifetime is the time user has to provide second factor after password login.
+var PartialTokenLifetime = time.Minute * 5
+
+// ErrSecondFactorRequired is returned by AuthCheck for tokens issued before second factor was verified.
+var ErrSecondFactorRequired = errors.New("second factor authentication required")
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns new random base32 encoded TOTP secret.
+func GenerateTOTPSecret() (string, error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(raw), nil
+}
+
+// TOTPProvisioningURI returns otpauth:// URI for secret, usually shown to the user as QR code.
+func TOTPProvisioningURI(secret, issuer, account string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", TOTPDigits))
+	params.Set("period", fmt.Sprintf("%d", TOTPPeriod))
+
+	label := url.PathEscape(issuer + ":" + account)
+	// authenticator apps don't decode '+' as space
+	return "otpauth://totp/" + label + "?" + strings.Replace(params.Encode(), "+", "%20", -1)
+}
+
+// TOTPCode returns RFC 6238 code for secret at time t.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(t.Unix()/TOTPPeriod)), nil
+}
+
+// VerifyTOTP checks code against secret at the current time, accepting codes
+// up to skew periods before and after to tolerate clock drift.
+func VerifyTOTP(secret, code string, skew uint) (bool, error) {
+	_, ok, err := VerifyTOTPCounter(secret, code, time.Now(), skew)
+	return ok, err
+}
+
+// VerifyTOTPCounter is like VerifyTOTP but also returns the matched time step counter.
+// Callers should store it and reject codes with counter not greater than the stored one to prevent replay.
+func VerifyTOTPCounter(secret, code string, t time.Time, skew uint) (counter uint64, ok bool, err error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return 0, false, err
+	}
+
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != TOTPDigits {
+		return 0, false, nil
+	}
+
+	now := uint64(t.Unix() / TOTPPeriod)
+	for i := -int64(skew); i <= int64(skew); i++ {
+		c := uint64(int64(now) + i)
+		if subtle.ConstantTimeCompare([]byte(hotp(key, c)), []byte(code)) == 1 {
+			return c, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	return b32.DecodeString(strings.TrimRight(secret, "="))
+}
+
+// hotp implements RFC 4226.
+func hotp(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, bin%mod)
+}
+
+// GenerateRecoveryCodes returns n one-time recovery codes to show to the user
+// and their hashes (created with HashPassword) to be stored.
+func GenerateRecoveryCodes(n int) (codes, hashes []string, err error) {
+	for i := 0; i < n; i++ {
+		raw := make([]byte, 10)
+		if _, err = rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		c := strings.ToLower(b32.EncodeToString(raw))
+
+		hash, err := HashPassword(c)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes = append(codes, c[:4]+"-"+c[4:8]+"-"+c[8:12]+"-"+c[12:])
+		hashes = append(hashes, hash)
+	}
+
+	return codes, hashes, nil
+}
+
+// VerifyRecoveryCode returns index of the hash in hashes that code matches.
+// Matched hash should be removed from storage since every code can be used only once.
+// Every call verifies up to len(hashes) password hashes, so rate limit the endpoint using it.
+func VerifyRecoveryCode(code string, hashes []string) (index int, ok bool) {
+	code = normalizeRecoveryCode(code)
+	for i, h := range hashes {
+		if match, _, err := VerifyPassword(code, h); err == nil && match {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// normalizeRecoveryCode drops separators and whitespace users may or may not type.
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
+
+// NewPartialAuthToken returns token for user that passed password check but still has to
+// provide second factor. It's only accepted by SecondFactorCheck.
+func NewPartialAuthToken(username string, roleName string, roleID int64) (TokenClaims, error) {
+	return NewAuthToken(username, roleName, roleID, WithLifetime(PartialTokenLifetime), func(b *tokenBuilder) {
+		b.claims.Partial = true
+	})
+}
+
+// SecondFactorCheck validates partial token from req. Fully authenticated tokens are rejected.
+func SecondFactorCheck(req *http.Request) (*TokenClaims, error) {
+	claims, err := RequestClaims(req)
+	if err != nil {
+		return claims, err
+	}
+
+	if !claims.Partial {
+		return claims, errors.New("token is not awaiting second factor")
+	}
+
+	return claims, nil
+}
+
+// CompleteSecondFactor revokes partial token described by claims and issues full token pair.
+// Call it after the second factor has been verified.
+func CompleteSecondFactor(claims *TokenClaims) (TokenPair, error) {
+	if !claims.Partial {
+		return TokenPair{}, errors.New("token is not awaiting second factor")
+	}
+
+	if err := RevokeAuthToken(claims); err != nil {
+		return TokenPair{}, err
+	}
+
+	return IssueTokenPair(claims.Username, claims.RoleName, claims.RoleID)
+}
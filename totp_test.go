@@ -0,0 +1,143 @@
+package webutility
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newBearerRequest(token string) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+// RFC 6238 appendix B, SHA1 with secret "12345678901234567890". Codes are the last 6 of 8 digits.
+func TestTOTPCodeRFC6238(t *testing.T) {
+	secret := b32.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyTOTPCounter(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1111111111, 0)
+
+	prev, _ := TOTPCode(secret, now.Add(-TOTPPeriod*time.Second))
+	old, _ := TOTPCode(secret, now.Add(-2*TOTPPeriod*time.Second))
+
+	counter, ok, err := VerifyTOTPCounter(secret, prev[:3]+" "+prev[3:], now, 1)
+	if err != nil || !ok {
+		t.Fatalf("code within skew rejected: %v", err)
+	}
+	if want := uint64(now.Unix()/TOTPPeriod) - 1; counter != want {
+		t.Errorf("counter = %d, want %d", counter, want)
+	}
+
+	if _, ok, _ = VerifyTOTPCounter(secret, old, now, 1); ok {
+		t.Error("code outside skew accepted")
+	}
+	if _, ok, _ = VerifyTOTPCounter(secret, "12345", now, 1); ok {
+		t.Error("short code accepted")
+	}
+	if _, _, err = VerifyTOTPCounter("not base32!", "123456", now, 1); err == nil {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	policy := DefaultPasswordPolicy
+	DefaultPasswordPolicy = testPasswordPolicy
+	defer func() { DefaultPasswordPolicy = policy }()
+
+	codes, hashes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 || len(hashes) != 10 {
+		t.Fatalf("got %d codes and %d hashes, want 10", len(codes), len(hashes))
+	}
+
+	for i, code := range codes {
+		if !strings.HasPrefix(hashes[i], "$"+PasswordArgon2id+"$") {
+			t.Errorf("hash %d = %s, want one made by HashPassword", i, hashes[i])
+		}
+		if idx, ok := VerifyRecoveryCode(code, hashes); !ok || idx != i {
+			t.Errorf("VerifyRecoveryCode(%s) = %d, %v, want %d, true", code, idx, ok, i)
+		}
+	}
+
+	// users may type codes without separators or in upper case
+	typed := strings.ToUpper(strings.Replace(codes[3], "-", "", -1))
+	if idx, ok := VerifyRecoveryCode(" "+typed+" ", hashes); !ok || idx != 3 {
+		t.Errorf("VerifyRecoveryCode(%s) = %d, %v, want 3, true", typed, idx, ok)
+	}
+
+	if _, ok := VerifyRecoveryCode("aaaa-aaaa-aaaa-aaaa", hashes); ok {
+		t.Error("unknown code accepted")
+	}
+
+	// malformed rows are skipped
+	bad := []string{"", "$hmac-sha256$zz$zz", "$argon2id$v=19$m=8,t=1,p=1$c2FsdHNhbHQ$", hashes[0]}
+	if idx, ok := VerifyRecoveryCode(codes[0], bad); !ok || idx != 3 {
+		t.Errorf("VerifyRecoveryCode with malformed hashes = %d, %v, want 3, true", idx, ok)
+	}
+}
+
+func TestPartialToken(t *testing.T) {
+	useKeys(t, NewKeySet(NewHMACKey("", []byte("secret"))))
+	useTokenStores(t)
+
+	partial, err := NewPartialAuthToken("alice", "admin", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := newBearerRequest(partial.Token)
+	if _, err = AuthCheck(req, ""); err != ErrSecondFactorRequired {
+		t.Errorf("AuthCheck err = %v, want ErrSecondFactorRequired", err)
+	}
+
+	claims, err := SecondFactorCheck(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := CompleteSecondFactor(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = SecondFactorCheck(req); err != ErrTokenRevoked {
+		t.Errorf("partial token after completion: err = %v, want ErrTokenRevoked", err)
+	}
+	if _, err = SecondFactorCheck(newBearerRequest(pair.Token)); err == nil {
+		t.Error("full token accepted as partial")
+	}
+	if _, err = AuthCheck(newBearerRequest(pair.Token), "admin"); err != nil {
+		t.Errorf("full token rejected: %v", err)
+	}
+}
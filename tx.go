@@ -0,0 +1,241 @@
+package webutility
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTxRetries is number of times WithTx retries transactions that failed on serialization
+// or deadlock errors, when TxOptions don't say otherwise.
+var DefaultTxRetries = 3
+
+// TxRetryBackoff is wait before the first retry. It doubles with each following retry.
+var TxRetryBackoff = 50 * time.Millisecond
+
+// ErrSavepointsUnsupported is returned by Tx.Savepoint for drivers without known savepoint syntax.
+var ErrSavepointsUnsupported = errors.New("savepoints not supported")
+
+// ErrNoDatabase is returned by WithTx when server has no database to run the transaction on.
+var ErrNoDatabase = errors.New("no database")
+
+// TxOptions ...
+type TxOptions struct {
+	// DB is name of the database (see DBFor), s.DB if empty.
+	DB        string
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	// Retries overrides DefaultTxRetries. Negative disables retries.
+	Retries int
+}
+
+// Tx is a transaction started by WithTx.
+type Tx struct {
+	*sql.Tx
+	driver     string
+	savepoints int
+}
+
+// WithTx runs fn in a transaction that is committed if fn returns nil and rolled back if it returns
+// an error or panics (the panic is then propagated). Transactions failing on serialization or deadlock
+// errors, as classified for the database's driver by IsRetryableTxError, are retried with backoff.
+// fn may therefore be called more than once and shouldn't have side effects outside the transaction.
+// Tx.Savepoint isn't available for odbc databases since savepoint syntax depends on the backend.
+func (s *Server) WithTx(ctx context.Context, opts *TxOptions, fn func(tx *Tx) error) error {
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+
+	db, driver := s.DB, s.defaultDriver()
+	if opts.DB != "" {
+		if db, driver = s.DBFor(opts.DB), s.DriverFor(opts.DB); db == nil {
+			return fmt.Errorf("unknown database %s", opts.DB)
+		}
+	}
+	if db == nil {
+		return ErrNoDatabase
+	}
+
+	retries := opts.Retries
+	if retries == 0 {
+		retries = DefaultTxRetries
+	}
+	txOpts := &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly}
+
+	wait := TxRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := runTx(ctx, db, driver, txOpts, fn)
+		if err == nil || attempt >= retries || !IsRetryableTxError(driver, err) {
+			return err
+		}
+
+		// jitter keeps conflicting transactions from retrying in lockstep
+		d := wait
+		if wait > 0 {
+			d = wait/2 + time.Duration(rand.Int63n(int64(wait)))
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(d):
+		}
+		wait *= 2
+	}
+}
+
+// defaultDriver returns driver of s.DB.
+func (s *Server) defaultDriver() string {
+	for name, db := range s.DBs {
+		if db == s.DB {
+			return s.DriverFor(name)
+		}
+	}
+	return ""
+}
+
+func runTx(ctx context.Context, db *sql.DB, driver string, opts *sql.TxOptions, fn func(tx *Tx) error) (err error) {
+	sqlTx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	tx := &Tx{Tx: sqlTx, driver: driver}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		if rerr := tx.Rollback(); rerr != nil && !errors.Is(rerr, sql.ErrTxDone) {
+			return fmt.Errorf("%w (rollback failed: %s)", err, rerr.Error())
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Savepoint runs fn within a savepoint of tx. If fn returns an error or panics, changes made by fn are
+// rolled back and the transaction can continue. Savepoints can be nested.
+func (tx *Tx) Savepoint(fn func(tx *Tx) error) (err error) {
+	stmts, ok := savepointSyntax(tx.driver)
+	if !ok {
+		return ErrSavepointsUnsupported
+	}
+
+	tx.savepoints++
+	name := fmt.Sprintf("sp_%d", tx.savepoints)
+
+	if _, err = tx.Exec(fmt.Sprintf(stmts[0], name)); err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Exec(fmt.Sprintf(stmts[1], name))
+			panic(p)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		if _, rerr := tx.Exec(fmt.Sprintf(stmts[1], name)); rerr != nil {
+			return fmt.Errorf("%w (rollback to savepoint failed: %s)", err, rerr.Error())
+		}
+		return err
+	}
+
+	if stmts[2] != "" {
+		_, err = tx.Exec(fmt.Sprintf(stmts[2], name))
+	}
+	return err
+}
+
+// savepointSyntax returns create, rollback and release statements for driver.
+// Release is empty for databases that don't have it.
+func savepointSyntax(driver string) ([3]string, bool) {
+	switch driver {
+	case "mysql", "postgres", "pgx", "sqlite3", "sqlite":
+		return [3]string{"SAVEPOINT %s", "ROLLBACK TO SAVEPOINT %s", "RELEASE SAVEPOINT %s"}, true
+	case "ora", "oracle", "godror", "oci8":
+		return [3]string{"SAVEPOINT %s", "ROLLBACK TO SAVEPOINT %s", ""}, true
+	case "sqlserver", "mssql":
+		return [3]string{"SAVE TRANSACTION %s", "ROLLBACK TRANSACTION %s", ""}, true
+	}
+	return [3]string{}, false
+}
+
+var (
+	retryableMu  sync.RWMutex
+	retryableTxs = map[string]func(err error) bool{}
+)
+
+// RegisterRetryableTxError registers fn that reports whether err of driver is transient
+// (serialization failure, deadlock) and the transaction can be retried.
+// It's consulted before the built-in classification.
+func RegisterRetryableTxError(driver string, fn func(err error) bool) {
+	retryableMu.Lock()
+	defer retryableMu.Unlock()
+	retryableTxs[driver] = fn
+}
+
+// sqlStater is implemented by errors of some drivers (pgx, lib/pq).
+type sqlStater interface {
+	SQLState() string
+}
+
+// IsRetryableTxError reports whether err returned by driver means the transaction failed
+// on serialization or deadlock and can be retried.
+func IsRetryableTxError(driver string, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	retryableMu.RLock()
+	fn, ok := retryableTxs[driver]
+	retryableMu.RUnlock()
+	if ok && fn(err) {
+		return true
+	}
+
+	var ss sqlStater
+	if errors.As(err, &ss) {
+		switch ss.SQLState() {
+		case "40001", "40P01":
+			return true
+		}
+	}
+
+	msg := err.Error()
+	var markers []string
+	switch driver {
+	case "mysql":
+		// ER_LOCK_DEADLOCK, ER_LOCK_WAIT_TIMEOUT
+		markers = []string{"Error 1213", "Error 1205"}
+	case "ora", "oracle", "godror", "oci8":
+		// deadlock, can't serialize access
+		markers = []string{"ORA-00060", "ORA-08177"}
+	case "postgres", "pgx":
+		markers = []string{"40001", "40P01", "could not serialize access", "deadlock detected"}
+	case "sqlserver", "mssql":
+		markers = []string{"deadlock victim", "Error 1205"}
+	case "sqlite3", "sqlite":
+		markers = []string{"database is locked", "SQLITE_BUSY"}
+	default:
+		// ODBC and others report SQLSTATE in the message
+		markers = []string{"40001", "40P01"}
+	}
+
+	for _, m := range markers {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package webutility
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWithTxNoDatabase(t *testing.T) {
+	s := new(Server)
+	err := s.WithTx(context.Background(), nil, func(tx *Tx) error {
+		t.Error("fn called without database")
+		return nil
+	})
+	if err != ErrNoDatabase {
+		t.Errorf("err = %v, want ErrNoDatabase", err)
+	}
+
+	if err = s.WithTx(context.Background(), &TxOptions{DB: "reports"}, nil); err == nil {
+		t.Error("unknown database accepted")
+	}
+}
+
+func TestSavepointSyntax(t *testing.T) {
+	for _, driver := range []string{"postgres", "mysql", "oracle", "sqlserver", "sqlite3"} {
+		if _, ok := savepointSyntax(driver); !ok {
+			t.Errorf("%s: no savepoint syntax", driver)
+		}
+	}
+	if _, ok := savepointSyntax("odbc"); ok {
+		t.Error("odbc: savepoints reported as supported")
+	}
+}
@@ -0,0 +1,376 @@
+package webutility
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ValidationErrors holds all field errors found by Validate.
+type ValidationErrors []FieldError
+
+// Error ...
+func (ve ValidationErrors) Error() string {
+	msgs := make([]string, len(ve))
+	for i, e := range ve {
+		msgs[i] = e.Field + ": " + e.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// StatusCode ...
+func (ve ValidationErrors) StatusCode() int {
+	return http.StatusUnprocessableEntity
+}
+
+// Problem returns ve as 422 Unprocessable Entity problem with per-field errors.
+func (ve ValidationErrors) Problem() *Problem {
+	return NewProblem(http.StatusUnprocessableEntity, "validation_failed", "request validation failed").
+		WithFieldErrors(ve)
+}
+
+// Localize returns copy of ve with messages translated to loc. Dictionary keys are
+// "validation.<code>", e.g. "validation.required", and may contain {field} and {param} placeholders.
+// Messages without translation are left unchanged.
+func (ve ValidationErrors) Localize(d *Dictionary, loc string) ValidationErrors {
+	out := make(ValidationErrors, len(ve))
+	for i, e := range ve {
+		out[i] = e
+		if msg := d.Translate(loc, "validation."+e.Code); msg != "" {
+			msg = strings.Replace(msg, "{field}", e.Field, -1)
+			msg = strings.Replace(msg, "{param}", e.param, -1)
+			out[i].Message = msg
+		}
+	}
+	return out
+}
+
+// validationMessages are default (english) messages for validation codes.
+var validationMessages = map[string]string{
+	"required": "is required",
+	"min":      "must be at least {param}",
+	"max":      "must be at most {param}",
+	"len":      "must have length {param}",
+	"regex":    "has invalid format",
+	"email":    "must be a valid email address",
+	"oneof":    "must be one of: {param}",
+	"date":     "must be a date in format {param}",
+}
+
+// dateFormats maps date_util.go layout names usable in 'date' rule.
+var dateFormats = map[string]string{
+	"YYYYMMDD_sl":        YYYYMMDD_sl,
+	"YYYYMMDD_ds":        YYYYMMDD_ds,
+	"YYYYMMDD_dt":        YYYYMMDD_dt,
+	"DDMMYYYY_sl":        DDMMYYYY_sl,
+	"DDMMYYYY_ds":        DDMMYYYY_ds,
+	"DDMMYYYY_dt":        DDMMYYYY_dt,
+	"YYYYMMDD_HHMMSS_sl": YYYYMMDD_HHMMSS_sl,
+	"YYYYMMDD_HHMMSS_ds": YYYYMMDD_HHMMSS_ds,
+	"YYYYMMDD_HHMMSS_dt": YYYYMMDD_HHMMSS_dt,
+	"DDMMYYYY_HHMMSS_sl": DDMMYYYY_HHMMSS_sl,
+	"DDMMYYYY_HHMMSS_ds": DDMMYYYY_HHMMSS_ds,
+	"DDMMYYYY_HHMMSS_dt": DDMMYYYY_HHMMSS_dt,
+}
+
+var regexCache sync.Map
+
+type validationRule struct {
+	name  string
+	param string
+}
+
+// parseRules splits validate tag into rules. Regex rule takes the rest of the tag
+// so the expression may contain commas, which means it must be the last rule.
+func parseRules(tag string) (rules []validationRule) {
+	for tag != "" {
+		var part string
+		if strings.HasPrefix(tag, "regex=") {
+			part, tag = tag, ""
+		} else if i := strings.Index(tag, ","); i != -1 {
+			part, tag = tag[:i], tag[i+1:]
+		} else {
+			part, tag = tag, ""
+		}
+
+		r := validationRule{name: strings.TrimSpace(part)}
+		if i := strings.Index(part, "="); i != -1 {
+			r.name, r.param = strings.TrimSpace(part[:i]), part[i+1:]
+		}
+		if r.name != "" {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// Validate checks struct v against rules in its `validate` tags and returns ValidationErrors
+// with all failed fields, or nil. Supported rules:
+//
+//	required        value must not be zero; Null* types must be valid
+//	min=n, max=n    numbers are compared by value, strings and slices by length
+//	len=n           exact length of string or slice
+//	oneof=a b c     value must be one of space separated options
+//	email           valid email address
+//	date=layout     string is a date in layout, either time layout or date_util.go constant name
+//	regex=expr      string matches expr; must be the last rule in the tag
+//
+// Null* types from nullables.go and empty strings and slices are optional: rules other than required
+// are checked only if they are set.
+// Nested structs and slices of structs are validated too.
+func Validate(v interface{}) error {
+	var errs ValidationErrors
+	validateValue(reflect.ValueOf(v), "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateValue(rv reflect.Value, prefix string, errs *ValidationErrors) {
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			validateValue(rv.Index(i), fmt.Sprintf("%s[%d]", prefix, i), errs)
+		}
+		return
+	case reflect.Struct:
+	default:
+		return
+	}
+
+	if _, ok := nullableValue(rv); ok || rv.Type() == reflect.TypeOf(time.Time{}) {
+		return
+	}
+
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		name := fieldName(sf)
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		fv := rv.Field(i)
+
+		if tag := sf.Tag.Get("validate"); tag != "" && tag != "-" {
+			validateField(fv, name, parseRules(tag), errs)
+		}
+
+		validateValue(fv, name, errs)
+	}
+}
+
+// fieldName returns name of the field as seen by the client.
+func fieldName(sf reflect.StructField) string {
+	for _, key := range []string{"json", "form", "path"} {
+		if name := strings.Split(sf.Tag.Get(key), ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+	return sf.Name
+}
+
+// nullableTypes are Null* types of this package and database/sql, validated by their inner value.
+var nullableTypes = map[reflect.Type]bool{
+	reflect.TypeOf(NullBool{}):        true,
+	reflect.TypeOf(NullString{}):      true,
+	reflect.TypeOf(NullInt64{}):       true,
+	reflect.TypeOf(NullFloat64{}):     true,
+	reflect.TypeOf(NullDateTime{}):    true,
+	reflect.TypeOf(NullDate{}):        true,
+	reflect.TypeOf(NullTime{}):        true,
+	reflect.TypeOf(sql.NullBool{}):    true,
+	reflect.TypeOf(sql.NullString{}):  true,
+	reflect.TypeOf(sql.NullByte{}):    true,
+	reflect.TypeOf(sql.NullInt16{}):   true,
+	reflect.TypeOf(sql.NullInt32{}):   true,
+	reflect.TypeOf(sql.NullInt64{}):   true,
+	reflect.TypeOf(sql.NullFloat64{}): true,
+	reflect.TypeOf(sql.NullTime{}):    true,
+}
+
+// nullableValue returns inner value of Null* type and whether rv is one.
+// Inner value is invalid if the nullable is null.
+func nullableValue(rv reflect.Value) (reflect.Value, bool) {
+	if !nullableTypes[rv.Type()] {
+		return reflect.Value{}, false
+	}
+
+	if !rv.FieldByName("Valid").Bool() {
+		return reflect.Value{}, true
+	}
+	if rv.Type().Field(0).Name == "Valid" {
+		return rv.Field(1), true
+	}
+	return rv.Field(0), true
+}
+
+func validateField(fv reflect.Value, name string, rules []validationRule, errs *ValidationErrors) {
+	for fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			fv = reflect.Value{}
+			break
+		}
+		fv = fv.Elem()
+	}
+
+	nullable := false
+	if fv.IsValid() {
+		var inner reflect.Value
+		if inner, nullable = nullableValue(fv); nullable {
+			fv = inner
+		}
+	}
+
+	// valid Null* values are present even when they hold zero value
+	present := fv.IsValid() && (nullable || !fv.IsZero())
+	for _, r := range rules {
+		if r.name == "required" {
+			if !present {
+				addFieldError(errs, name, r)
+				return
+			}
+			continue
+		}
+
+		// optional values are only checked when set
+		if !fv.IsValid() || (!present && lengthKind(fv.Kind())) {
+			return
+		}
+
+		if ok, known := checkRule(fv, r); !known {
+			*errs = append(*errs, FieldError{Field: name, Code: "invalid", Message: "unknown validation rule " + r.name})
+		} else if !ok {
+			addFieldError(errs, name, r)
+		}
+	}
+}
+
+func addFieldError(errs *ValidationErrors, name string, r validationRule) {
+	param := r.param
+	switch r.name {
+	case "oneof":
+		param = strings.Join(strings.Fields(param), ", ")
+	case "date":
+		if l, ok := dateFormats[param]; ok {
+			param = l
+		}
+	}
+	msg := strings.Replace(validationMessages[r.name], "{param}", param, -1)
+	*errs = append(*errs, FieldError{Field: name, Code: r.name, Message: msg, param: param})
+}
+
+func checkRule(fv reflect.Value, r validationRule) (ok, known bool) {
+	switch r.name {
+	case "min", "max":
+		limit, err := strconv.ParseFloat(r.param, 64)
+		if err != nil {
+			return false, true
+		}
+		n, isNum := numericValue(fv)
+		if !isNum {
+			n = float64(lengthOf(fv))
+		}
+		if r.name == "min" {
+			return n >= limit, true
+		}
+		return n <= limit, true
+
+	case "len":
+		n, err := strconv.Atoi(r.param)
+		return err == nil && lengthOf(fv) == n, true
+
+	case "oneof":
+		s := fmt.Sprint(fv.Interface())
+		for _, opt := range strings.Fields(r.param) {
+			if s == opt {
+				return true, true
+			}
+		}
+		return false, true
+
+	case "email":
+		if fv.Kind() != reflect.String {
+			return false, true
+		}
+		addr, err := mail.ParseAddress(fv.String())
+		return err == nil && addr.Address == fv.String(), true
+
+	case "date":
+		if fv.Kind() != reflect.String {
+			return false, true
+		}
+		layout := r.param
+		if l, ok := dateFormats[layout]; ok {
+			layout = l
+		}
+		_, err := time.Parse(layout, fv.String())
+		return err == nil, true
+
+	case "regex":
+		if fv.Kind() != reflect.String {
+			return false, true
+		}
+		re, err := compileRegex(r.param)
+		return err == nil && re.MatchString(fv.String()), true
+	}
+
+	return false, false
+}
+
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	if re, ok := regexCache.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	regexCache.Store(expr, re)
+	return re, nil
+}
+
+func numericValue(fv reflect.Value) (float64, bool) {
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(fv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(fv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return fv.Float(), true
+	}
+	return 0, false
+}
+
+func lengthKind(k reflect.Kind) bool {
+	return k == reflect.String || k == reflect.Slice || k == reflect.Map
+}
+
+func lengthOf(fv reflect.Value) int {
+	switch fv.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(fv.String())
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return fv.Len()
+	}
+	return 0
+}
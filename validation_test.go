@@ -0,0 +1,200 @@
+package webutility
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// failedFields returns field -> code of errors returned by Validate.
+func failedFields(t *testing.T, v interface{}) map[string]string {
+	t.Helper()
+	err := Validate(v)
+	if err == nil {
+		return nil
+	}
+	ve, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("err is %T, want ValidationErrors", err)
+	}
+	fields := make(map[string]string)
+	for _, e := range ve {
+		fields[e.Field] = e.Code
+	}
+	return fields
+}
+
+func TestValidateRules(t *testing.T) {
+	type item struct {
+		Name string `json:"name" validate:"required"`
+	}
+	type request struct {
+		Name   string   `json:"name" validate:"required,min=2,max=5"`
+		Age    int      `json:"age" validate:"min=18"`
+		Code   string   `json:"code" validate:"len=3"`
+		Status string   `json:"status" validate:"oneof=active inactive"`
+		Email  string   `json:"email" validate:"email"`
+		Date   string   `json:"date" validate:"date=YYYYMMDD_ds"`
+		Phone  string   `json:"phone" validate:"regex=^[0-9]{3,}$"`
+		Items  []item   `json:"items"`
+		Tags   []string `json:"tags" validate:"max=2"`
+	}
+
+	valid := request{
+		Name: "alice", Age: 20, Code: "abc", Status: "active", Email: "alice@example.com",
+		Date: "2024-01-31", Phone: "12345", Items: []item{{Name: "a"}}, Tags: []string{"a"},
+	}
+	if fields := failedFields(t, &valid); fields != nil {
+		t.Errorf("valid request failed: %v", fields)
+	}
+
+	// empty optional values are not checked
+	if fields := failedFields(t, &request{Name: "alice", Age: 18}); fields != nil {
+		t.Errorf("empty optional values failed: %v", fields)
+	}
+
+	invalid := request{
+		Name: "a", Age: 17, Code: "abcd", Status: "deleted", Email: "alice",
+		Date: "31.01.2024", Phone: "12a", Items: []item{{}, {Name: "b"}}, Tags: []string{"a", "b", "c"},
+	}
+	want := map[string]string{
+		"name": "min", "age": "min", "code": "len", "status": "oneof", "email": "email",
+		"date": "date", "phone": "regex", "items[0].name": "required", "tags": "max",
+	}
+	fields := failedFields(t, &invalid)
+	for f, code := range want {
+		if fields[f] != code {
+			t.Errorf("%s: code = %q, want %q", f, fields[f], code)
+		}
+	}
+	if len(fields) != len(want) {
+		t.Errorf("failed fields = %v, want %v", fields, want)
+	}
+}
+
+func TestValidateRequiredNullables(t *testing.T) {
+	type request struct {
+		Count NullInt64   `json:"count" validate:"required"`
+		Flag  NullBool    `json:"flag" validate:"required"`
+		Name  NullString  `json:"name" validate:"required"`
+		Price NullFloat64 `json:"price" validate:"required,min=1"`
+	}
+
+	// valid nullables holding zero values are set
+	zero := request{
+		Count: NullInt64{Int64: 0, Valid: true},
+		Flag:  NullBool{Bool: false, Valid: true},
+		Name:  NullString{String: "", Valid: true},
+		Price: NullFloat64{Float64: 1, Valid: true},
+	}
+	if fields := failedFields(t, &zero); fields != nil {
+		t.Errorf("valid zero nullables failed: %v", fields)
+	}
+
+	want := map[string]string{"count": "required", "flag": "required", "name": "required", "price": "required"}
+	fields := failedFields(t, &request{})
+	if len(fields) != len(want) {
+		t.Errorf("failed fields = %v, want %v", fields, want)
+	}
+	for f, code := range want {
+		if fields[f] != code {
+			t.Errorf("%s: code = %q, want %q", f, fields[f], code)
+		}
+	}
+
+	// other rules apply to the inner value of valid nullables
+	zero.Price = NullFloat64{Float64: 0, Valid: true}
+	if fields = failedFields(t, &zero); fields["price"] != "min" {
+		t.Errorf("price: code = %q, want min", fields["price"])
+	}
+}
+
+func TestValidateOptionalNullables(t *testing.T) {
+	type request struct {
+		Age NullInt64 `json:"age" validate:"min=18"`
+	}
+
+	if fields := failedFields(t, &request{}); fields != nil {
+		t.Errorf("null optional value failed: %v", fields)
+	}
+	if fields := failedFields(t, &request{Age: NullInt64{Int64: 17, Valid: true}}); fields["age"] != "min" {
+		t.Errorf("age: code = %q, want min", fields["age"])
+	}
+}
+
+func TestValidateLookalikeNullable(t *testing.T) {
+	// not a Null* type even though it looks like one
+	type address struct {
+		City  string `json:"city" validate:"required"`
+		Valid bool   `json:"valid"`
+	}
+	type request struct {
+		Address address        `json:"address" validate:"required"`
+		Birth   sql.NullTime   `json:"birth" validate:"required"`
+		Count   sql.NullInt32  `json:"count" validate:"min=1"`
+		Name    sql.NullString `json:"name" validate:"min=2"`
+	}
+
+	fields := failedFields(t, &request{
+		Address: address{Valid: true},
+		Count:   sql.NullInt32{Int32: 0, Valid: true},
+		Name:    sql.NullString{},
+	})
+	want := map[string]string{"address.city": "required", "birth": "required", "count": "min"}
+	if len(fields) != len(want) {
+		t.Errorf("failed fields = %v, want %v", fields, want)
+	}
+	for f, code := range want {
+		if fields[f] != code {
+			t.Errorf("%s: code = %q, want %q", f, fields[f], code)
+		}
+	}
+
+	// required is checked like for any other struct
+	if fields = failedFields(t, &request{Address: address{City: "Novi Sad"}, Birth: sql.NullTime{Valid: true}}); fields != nil {
+		t.Errorf("valid request failed: %v", fields)
+	}
+}
+
+func TestValidationErrorsLocalize(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "sr.json"), []byte(`{
+		"validation.required": "{field} je obavezno",
+		"validation.min": "{field} mora biti najmanje {param}"
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDictionary()
+	if err = d.AddTranslations(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	type request struct {
+		Name  string `json:"name" validate:"required"`
+		Age   int    `json:"age" validate:"min=18"`
+		Email string `json:"email" validate:"email"`
+	}
+	ve, _ := Validate(&request{Age: 17, Email: "x"}).(ValidationErrors)
+
+	got := make(map[string]string)
+	for _, e := range ve.Localize(d, "sr") {
+		got[e.Field] = e.Message
+	}
+	want := map[string]string{
+		"name":  "name je obavezno",
+		"age":   "age mora biti najmanje 18",
+		"email": "must be a valid email address", // no translation
+	}
+	for f, msg := range want {
+		if got[f] != msg {
+			t.Errorf("%s: message = %q, want %q", f, got[f], msg)
+		}
+	}
+
+	// original errors are left as they were
+	if ve[0].Message == got[ve[0].Field] {
+		t.Error("Localize modified the original errors")
+	}
+}
@@ -0,0 +1,485 @@
+package webutility
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocketTokenParam is query parameter that can carry auth token on websocket handshakes,
+// since browsers can't set Authorization header for them.
+const WebSocketTokenParam = "access_token"
+
+// WebSocketTokenProtocol is subprotocol that marks the next offered subprotocol as auth token,
+// i.e. new WebSocket(url, ["bearer", token]).
+const WebSocketTokenProtocol = "bearer"
+
+// Websocket message types handled by WSHub itself.
+const (
+	WSJoin  = "join"
+	WSLeave = "leave"
+	WSError = "error"
+)
+
+// ErrSendQueueFull is returned when connection's send queue is full. Such connection is closed.
+var ErrSendQueueFull = errors.New("websocket send queue full")
+
+// ErrConnClosed ...
+var ErrConnClosed = errors.New("websocket connection closed")
+
+// WSMessage is JSON envelope of websocket messages in both directions.
+type WSMessage struct {
+	Type string `json:"type"`
+	Room string `json:"room,omitempty"`
+	// From is username of the sender, set by the hub for messages sent by clients.
+	From    string          `json:"from,omitempty"`
+	Payload *Payload        `json:"payload,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// NewWSMessage returns message with data encoded as JSON.
+func NewWSMessage(typ, room string, data interface{}) (WSMessage, error) {
+	raw, err := json.Marshal(data)
+	return WSMessage{Type: typ, Room: room, Data: raw}, err
+}
+
+// webSocketToken returns auth token of websocket handshake and subprotocol to answer with, if any.
+func webSocketToken(req *http.Request) (token, protocol string) {
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer "), ""
+	}
+	if token = req.URL.Query().Get(WebSocketTokenParam); token != "" {
+		return token, ""
+	}
+	protocols := websocket.Subprotocols(req)
+	for i := 0; i < len(protocols)-1; i++ {
+		if protocols[i] == WebSocketTokenProtocol {
+			return protocols[i+1], WebSocketTokenProtocol
+		}
+	}
+	return "", ""
+}
+
+// RedactWebSocketToken returns copy of req with token in WebSocketTokenParam query parameter and
+// WebSocketTokenProtocol subprotocol masked, for logging. req is returned as is if it has neither.
+func RedactWebSocketToken(req *http.Request) *http.Request {
+	q := req.URL.Query()
+	_, inQuery := q[WebSocketTokenParam]
+
+	protocols := websocket.Subprotocols(req)
+	inProtocol := false
+	for i := 0; i < len(protocols)-1; i++ {
+		if protocols[i] == WebSocketTokenProtocol {
+			protocols[i+1] = "REDACTED"
+			inProtocol = true
+		}
+	}
+
+	if !inQuery && !inProtocol {
+		return req
+	}
+
+	r := req.Clone(req.Context())
+	if inQuery {
+		q.Set(WebSocketTokenParam, "REDACTED")
+		r.URL.RawQuery = q.Encode()
+		r.RequestURI = r.URL.RequestURI()
+	}
+	if inProtocol {
+		r.Header.Set("Sec-Websocket-Protocol", strings.Join(protocols, ", "))
+	}
+	return r
+}
+
+// WebSocketAuth authenticates websocket handshakes with token in Authorization header,
+// WebSocketTokenParam query parameter or WebSocketTokenProtocol subprotocol.
+// Use it with middleware.AuthWith.
+func WebSocketAuth() Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) (*TokenClaims, error) {
+		token, _ := webSocketToken(req)
+		if token == "" {
+			return nil, ErrNoCredentials
+		}
+		return ParseAuthToken(token)
+	})
+}
+
+// WSConn is a client connection of WSHub.
+type WSConn struct {
+	// Claims of authenticated user, nil for anonymous connections.
+	Claims *TokenClaims
+
+	hub   *WSHub
+	ws    *websocket.Conn
+	send  chan []byte
+	done  chan struct{}
+	once  sync.Once
+	rooms map[string]struct{} // guarded by hub.mu
+}
+
+// Send queues m for sending. It never blocks: if the queue is full connection is closed
+// and ErrSendQueueFull returned.
+func (c *WSConn) Send(m WSMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return c.sendRaw(data)
+}
+
+func (c *WSConn) sendRaw(data []byte) error {
+	select {
+	case <-c.done:
+		return ErrConnClosed
+	default:
+	}
+
+	select {
+	case c.send <- data:
+		return nil
+	default:
+		c.Close()
+		return ErrSendQueueFull
+	}
+}
+
+// Close closes the connection.
+func (c *WSConn) Close() {
+	c.once.Do(func() {
+		close(c.done)
+	})
+}
+
+func (c *WSConn) username() string {
+	if c.Claims == nil {
+		return ""
+	}
+	return c.Claims.Username
+}
+
+// WSHub manages websocket connections and rooms.
+type WSHub struct {
+	Upgrader websocket.Upgrader
+
+	// SendQueue is number of messages queued per connection before it's considered too slow.
+	SendQueue      int
+	PingInterval   time.Duration
+	PongWait       time.Duration
+	WriteWait      time.Duration
+	MaxMessageSize int64
+
+	// OnMessage is called for each message received from a client, except join and leave.
+	OnMessage func(c *WSConn, m WSMessage)
+	// OnJoin decides whether c can join room it asked for with a join message.
+	// Rooms are closed to clients while it's nil; server code can still add connections with Join.
+	OnJoin func(c *WSConn, room string) bool
+	// OnConnect and OnDisconnect are called when connection is opened and closed.
+	OnConnect    func(c *WSConn)
+	OnDisconnect func(c *WSConn)
+
+	mu     sync.RWMutex
+	conns  map[*WSConn]struct{}
+	rooms  map[string]map[*WSConn]struct{}
+	closed bool
+}
+
+// NewWSHub ...
+func NewWSHub() *WSHub {
+	return &WSHub{
+		Upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+		},
+		SendQueue:      64,
+		PingInterval:   50 * time.Second,
+		PongWait:       60 * time.Second,
+		WriteWait:      10 * time.Second,
+		MaxMessageSize: 64 << 10,
+		conns:          make(map[*WSConn]struct{}),
+		rooms:          make(map[string]map[*WSConn]struct{}),
+	}
+}
+
+// ServeHTTP upgrades the connection and serves it until it's closed. Claims put in request's
+// context by authentication middleware are attached to the connection.
+func (h *WSHub) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var header http.Header
+	if _, protocol := webSocketToken(req); protocol != "" {
+		header = http.Header{"Sec-Websocket-Protocol": {protocol}}
+	}
+
+	ws, err := h.Upgrader.Upgrade(w, req, header)
+	if err != nil {
+		// Upgrade already responded with an error
+		return
+	}
+
+	c := &WSConn{
+		hub:   h,
+		ws:    ws,
+		send:  make(chan []byte, h.SendQueue),
+		done:  make(chan struct{}),
+		rooms: make(map[string]struct{}),
+	}
+	c.Claims, _ = ClaimsFromContext(req.Context())
+
+	if !h.register(c) {
+		ws.Close()
+		return
+	}
+	if h.OnConnect != nil {
+		h.OnConnect(c)
+	}
+
+	go h.writePump(c)
+	h.readPump(c)
+}
+
+func (h *WSHub) register(c *WSConn) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return false
+	}
+	h.conns[c] = struct{}{}
+	return true
+}
+
+func (h *WSHub) unregister(c *WSConn) {
+	h.mu.Lock()
+	for room := range c.rooms {
+		h.leave(c, room)
+	}
+	delete(h.conns, c)
+	h.mu.Unlock()
+
+	if h.OnDisconnect != nil {
+		h.OnDisconnect(c)
+	}
+}
+
+func (h *WSHub) readPump(c *WSConn) {
+	defer func() {
+		c.Close()
+		h.unregister(c)
+	}()
+
+	c.ws.SetReadLimit(h.MaxMessageSize)
+	c.ws.SetReadDeadline(time.Now().Add(h.PongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(h.PongWait))
+	})
+
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var m WSMessage
+		if err = json.Unmarshal(data, &m); err != nil {
+			c.Send(WSMessage{Type: WSError, Data: mustJSON("invalid message: " + err.Error())})
+			continue
+		}
+		m.From = c.username()
+
+		switch m.Type {
+		case WSJoin:
+			if err := h.clientJoin(c, m.Room); err != nil {
+				c.Send(WSMessage{Type: WSError, Room: m.Room, Data: mustJSON(err.Error())})
+			}
+		case WSLeave:
+			h.Leave(c, m.Room)
+		default:
+			if h.OnMessage != nil {
+				h.OnMessage(c, m)
+			}
+		}
+	}
+}
+
+func (h *WSHub) writePump(c *WSConn) {
+	ticker := time.NewTicker(h.PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.ws.Close()
+	}()
+
+	for {
+		select {
+		case data := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(h.WriteWait))
+			if err := c.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+				c.Close()
+				return
+			}
+		case <-ticker.C:
+			c.ws.SetWriteDeadline(time.Now().Add(h.WriteWait))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.Close()
+				return
+			}
+		case <-c.done:
+			c.ws.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(h.WriteWait))
+			return
+		}
+	}
+}
+
+// clientJoin adds c to room it asked for, if OnJoin allows it.
+func (h *WSHub) clientJoin(c *WSConn, room string) error {
+	if room == "" {
+		return errors.New("room not specified")
+	}
+	if h.OnJoin == nil || !h.OnJoin(c, room) {
+		return ErrPermissionDenied
+	}
+	return h.Join(c, room)
+}
+
+// Join adds c to room. OnJoin is not consulted.
+func (h *WSHub) Join(c *WSConn, room string) error {
+	if room == "" {
+		return errors.New("room not specified")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.conns[c]; !ok {
+		return ErrConnClosed
+	}
+	members, ok := h.rooms[room]
+	if !ok {
+		members = make(map[*WSConn]struct{})
+		h.rooms[room] = members
+	}
+	members[c] = struct{}{}
+	c.rooms[room] = struct{}{}
+	return nil
+}
+
+// Leave removes c from room.
+func (h *WSHub) Leave(c *WSConn, room string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.leave(c, room)
+}
+
+func (h *WSHub) leave(c *WSConn, room string) {
+	delete(c.rooms, room)
+	if members, ok := h.rooms[room]; ok {
+		delete(members, c)
+		if len(members) == 0 {
+			delete(h.rooms, room)
+		}
+	}
+}
+
+// Broadcast sends m to all connections in room. Connections that can't keep up are closed.
+func (h *WSHub) Broadcast(room string, m WSMessage) error {
+	m.Room = room
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.rooms[room] {
+		c.sendRaw(data)
+	}
+	return nil
+}
+
+// BroadcastAll sends m to all connections.
+func (h *WSHub) BroadcastAll(m WSMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.conns {
+		c.sendRaw(data)
+	}
+	return nil
+}
+
+// SendToUser sends m to all connections of user with username.
+func (h *WSHub) SendToUser(username string, m WSMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.conns {
+		if c.username() == username {
+			c.sendRaw(data)
+		}
+	}
+	return nil
+}
+
+// RoomSize returns number of connections in room.
+func (h *WSHub) RoomSize(room string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.rooms[room])
+}
+
+// Close closes all connections and rejects new ones.
+func (h *WSHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for c := range h.conns {
+		c.Close()
+	}
+}
+
+// HandleWebSocket registers hub on path. Clients are authenticated with WebSocketAuth and
+// checked against roles with AuthCheck. Hub's origin check defaults to s.CORS, if set.
+func (s *Server) HandleWebSocket(path, roles string, hub *WSHub) *mux.Route {
+	if hub.Upgrader.CheckOrigin == nil && s.CORS != nil {
+		hub.Upgrader.CheckOrigin = func(req *http.Request) bool {
+			origin := req.Header.Get("Origin")
+			return origin == "" || s.CORS.OriginAllowed(origin)
+		}
+	}
+	s.hubs = append(s.hubs, hub)
+
+	auth := WebSocketAuth()
+	return s.Router.HandleFunc(path, func(w http.ResponseWriter, req *http.Request) {
+		claims, err := auth.Authenticate(req)
+		if err != nil {
+			Unauthorized(w, req, err.Error())
+			return
+		}
+		req = req.WithContext(ContextWithClaims(req.Context(), claims))
+		if _, err = AuthCheck(req, roles); err != nil {
+			Unauthorized(w, req, err.Error())
+			return
+		}
+
+		hub.ServeHTTP(w, req)
+	}).Methods("GET")
+}
+
+func mustJSON(v interface{}) json.RawMessage {
+	data, _ := json.Marshal(v)
+	return data
+}
@@ -0,0 +1,100 @@
+package webutility
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialHub(t *testing.T, h *WSHub) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func readWSMessage(t *testing.T, ws *websocket.Conn) WSMessage {
+	t.Helper()
+	var m WSMessage
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	if err := ws.ReadJSON(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestWSHubJoinDeniedByDefault(t *testing.T) {
+	h := NewWSHub()
+	defer h.Close()
+
+	ws := dialHub(t, h)
+	ws.WriteJSON(WSMessage{Type: WSJoin, Room: "admins"})
+
+	if m := readWSMessage(t, ws); m.Type != WSError || m.Room != "admins" {
+		t.Errorf("got %+v, want error for room admins", m)
+	}
+	if n := h.RoomSize("admins"); n != 0 {
+		t.Errorf("RoomSize = %d, want 0", n)
+	}
+}
+
+func TestWSHubJoin(t *testing.T) {
+	h := NewWSHub()
+	defer h.Close()
+	h.OnJoin = func(c *WSConn, room string) bool {
+		return room == "public"
+	}
+
+	ws := dialHub(t, h)
+	ws.WriteJSON(WSMessage{Type: WSJoin, Room: "private"})
+	if m := readWSMessage(t, ws); m.Type != WSError {
+		t.Errorf("got %+v, want error", m)
+	}
+
+	ws.WriteJSON(WSMessage{Type: WSJoin, Room: "public"})
+	deadline := time.Now().Add(time.Second)
+	for h.RoomSize("public") == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	msg, _ := NewWSMessage("note", "", "hello")
+	if err := h.Broadcast("public", msg); err != nil {
+		t.Fatal(err)
+	}
+	if m := readWSMessage(t, ws); m.Type != "note" || m.Room != "public" || string(m.Data) != `"hello"` {
+		t.Errorf("got %+v", m)
+	}
+}
+
+func TestRedactWebSocketToken(t *testing.T) {
+	req := httptest.NewRequest("GET", "/ws?access_token=secret&room=a", nil)
+	req.Header.Set("Sec-WebSocket-Protocol", "bearer, secret2")
+
+	r := RedactWebSocketToken(req)
+	for _, s := range []string{r.URL.String(), r.RequestURI, r.Header.Get("Sec-WebSocket-Protocol")} {
+		if strings.Contains(s, "secret") {
+			t.Errorf("token not redacted: %s", s)
+		}
+	}
+	if r.URL.Query().Get("room") != "a" {
+		t.Errorf("other query parameters lost: %s", r.URL)
+	}
+	if req.URL.Query().Get(WebSocketTokenParam) != "secret" {
+		t.Error("original request was modified")
+	}
+
+	plain := httptest.NewRequest("GET", "/ws", nil)
+	plain.Header.Set("Authorization", "Bearer x")
+	if RedactWebSocketToken(plain) != plain {
+		t.Error("request without websocket token was copied")
+	}
+}